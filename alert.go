@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// alertRule watches one metric against a threshold.
+type alertRule struct {
+	metric    string
+	collector string // skip the rule when this collector failed
+	threshold float64
+	below     bool // fire when the value drops under threshold rather than reaching it
+	value     func(*Stats) float64
+}
+
+type alertPayload struct {
+	Hostname  string    `json:"hostname"`
+	Metric    string    `json:"metric"`
+	State     string    `json:"state"` // "firing" or "resolved"
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// alertHysteresis is how far, as a fraction of the threshold, a firing
+// metric must move back past it before the alert resolves, so a value
+// hovering at the threshold doesn't flap.
+const alertHysteresis = 0.05
+
+// alerter posts a webhook when a metric crosses its threshold and again
+// when it recovers, but not for every sample in between. check is called
+// from the sampler goroutine only, so firing needs no locking. Notifications
+// are sent one at a time by run, in the order the transitions happened.
+type alerter struct {
+	url    string
+	rules  []alertRule
+	firing map[string]bool
+	queue  chan alertPayload
+	client *http.Client
+}
+
+// alertQueueSize bounds the notifications waiting on a slow webhook;
+// beyond it they are dropped rather than holding up sampling.
+const alertQueueSize = 32
+
+// newAlerter returns nil when no webhook URL or no thresholds are set.
+// Rules for collectors left out of SECTIONS are dropped, since their values
+// stay zero and a below rule would fire on that.
+func newAlerter(cfg *Config) *alerter {
+	if cfg.WebhookURL == "" {
+		return nil
+	}
+	candidates := []alertRule{
+		{"cpu_percent", "cpu", cfg.CPUAlertPct, false, func(s *Stats) float64 { return s.CPUPercent }},
+		{"memory_percent", "memory", cfg.MemAlertPct, false, func(s *Stats) float64 { return s.Memory.Percent }},
+		{"disk_percent", "disk", cfg.DiskAlertPct, false, func(s *Stats) float64 { return s.Disk.Percent }},
+		{"disk_free_bytes", "disk", float64(cfg.DiskMinFreeBytes), true, func(s *Stats) float64 { return float64(s.Disk.Free) }},
+	}
+	a := &alerter{
+		url:    cfg.WebhookURL,
+		firing: make(map[string]bool),
+		queue:  make(chan alertPayload, alertQueueSize),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, r := range candidates {
+		if r.threshold > 0 && cfg.Sections.has(r.collector) {
+			a.rules = append(a.rules, r)
+		}
+	}
+	if len(a.rules) == 0 {
+		return nil
+	}
+	return a
+}
+
+// check evaluates every rule against s and notifies on state changes.
+func (a *alerter) check(s *Stats) {
+	for _, r := range a.rules {
+		if _, failed := s.Errors[r.collector]; failed {
+			continue
+		}
+		value := r.value(s)
+		// Once firing, the threshold moves back by the margin.
+		threshold := r.threshold
+		margin := r.threshold * alertHysteresis
+		if a.firing[r.metric] {
+			if r.below {
+				threshold += margin
+			} else {
+				threshold -= margin
+			}
+		}
+		over := value >= threshold
+		if r.below {
+			over = value < threshold
+		}
+		if over == a.firing[r.metric] {
+			continue
+		}
+		a.firing[r.metric] = over
+
+		state := "resolved"
+		if over {
+			state = "firing"
+		}
+		p := alertPayload{
+			Hostname:  s.Hostname,
+			Metric:    r.metric,
+			State:     state,
+			Value:     value,
+			Threshold: r.threshold,
+			Timestamp: s.Timestamp,
+		}
+		select {
+		case a.queue <- p:
+		default:
+			slog.Warn("Alert queue full, dropping notification", "metric", p.Metric, "state", p.State)
+		}
+	}
+}
+
+// run sends queued notifications until ctx is done.
+func (a *alerter) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-a.queue:
+			a.notify(p)
+		}
+	}
+}
+
+func (a *alerter) notify(p alertPayload) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		slog.Error("Alert failed", "err", err)
+		return
+	}
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Alert failed", "metric", p.Metric, "state", p.State, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Error("Alert webhook failed", "metric", p.Metric, "state", p.State, "status", resp.Status)
+	}
+}
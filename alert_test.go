@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestAlerterSkipsDisabledSections(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.WebhookURL = "http://127.0.0.1:1/hook"
+	cfg.Sections = parseSections("cpu,memory")
+	cfg.CPUAlertPct = 90
+	cfg.DiskMinFreeBytes = 1000
+
+	a := newAlerter(cfg)
+	if a == nil {
+		t.Fatal("newAlerter returned nil with a CPU threshold set")
+	}
+	// Disk was never collected, so Disk.Free is zero.
+	a.check(&Stats{CPUPercent: 10})
+	for metric, firing := range a.firing {
+		if firing {
+			t.Errorf("%s fired with its collector disabled", metric)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// anomalyWindow is how far back findAnomalies looks for recent behaviour.
+const anomalyWindow = 10 * time.Minute
+
+// minAnomalySamples is how many samples findAnomalies needs before it
+// trusts the mean and standard deviation.
+const minAnomalySamples = 10
+
+// anomalyMetric is a metric checked by findAnomalies. The standard
+// deviation is floored at minStddev, as an idle host's near-constant
+// readings would otherwise make any blip look like many sigma.
+type anomalyMetric struct {
+	name      string
+	collector string
+	value     func(*Stats) float64
+	minStddev float64
+}
+
+var anomalyMetrics = []anomalyMetric{
+	{"cpu_percent", "cpu", func(s *Stats) float64 { return s.CPUPercent }, 5},
+	{"load1", "load", func(s *Stats) float64 { return s.Load.Load1 }, 0.5},
+}
+
+// findAnomalies lists the metrics in cur that are more than sigma standard
+// deviations from their mean over the last anomalyWindow of h. Metrics
+// whose collector didn't run or failed are skipped, as are samples where
+// it failed. A sigma of 0 disables the check.
+func findAnomalies(h *history, cur *Stats, sections sectionSet, sigma float64, now time.Time) []string {
+	if sigma <= 0 {
+		return nil
+	}
+	samples, _ := h.samples(now.Add(-anomalyWindow), time.Time{}, 0)
+	var found []string
+	for _, m := range anomalyMetrics {
+		if _, failed := cur.Errors[m.collector]; failed || !sections.has(m.collector) {
+			continue
+		}
+		var n, sum, sumSq float64
+		for _, s := range samples {
+			if _, failed := s.Errors[m.collector]; failed {
+				continue
+			}
+			v := m.value(s)
+			n++
+			sum += v
+			sumSq += v * v
+		}
+		if n < minAnomalySamples {
+			continue
+		}
+		mean := sum / n
+		stddev := math.Sqrt(max(sumSq/n-mean*mean, 0))
+		if math.Abs(m.value(cur)-mean) > sigma*max(stddev, m.minStddev) {
+			found = append(found, m.name)
+		}
+	}
+	return found
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const powerSupplyDir = "/sys/class/power_supply"
+
+type BatteryStats struct {
+	Present  bool    `json:"present"`
+	Percent  float64 `json:"percent,omitempty"`
+	Charging bool    `json:"charging,omitempty"`
+	// TimeRemaining is the estimated time to empty while discharging, or to
+	// full while charging. It is omitted when the battery doesn't report
+	// enough to estimate it.
+	TimeRemaining uint64 `json:"time_remaining_seconds,omitempty"`
+}
+
+// collectBattery reads the first battery under /sys/class/power_supply.
+// Hosts without one (or without sysfs, i.e. anything but Linux) report
+// Present: false rather than an error.
+func collectBattery(ctx context.Context, cfg *Config, s *Stats) error {
+	s.Battery = BatteryStats{}
+	dirs, _ := filepath.Glob(filepath.Join(powerSupplyDir, "*"))
+	for _, dir := range dirs {
+		if readSysfs(dir, "type") != "Battery" {
+			continue
+		}
+		capacity, err := strconv.ParseFloat(readSysfs(dir, "capacity"), 64)
+		if err != nil {
+			continue
+		}
+		status := readSysfs(dir, "status")
+		s.Battery = BatteryStats{
+			Present:       true,
+			Percent:       capacity,
+			Charging:      status == "Charging",
+			TimeRemaining: batteryTimeRemaining(dir, status == "Charging"),
+		}
+		return nil
+	}
+	return nil
+}
+
+// batteryTimeRemaining estimates seconds to empty (or to full when
+// charging) from either the energy (µWh/µW) or charge (µAh/µA) readings.
+func batteryTimeRemaining(dir string, charging bool) uint64 {
+	for _, f := range [][3]string{
+		{"energy_now", "energy_full", "power_now"},
+		{"charge_now", "charge_full", "current_now"},
+	} {
+		now, errNow := readSysfsUint(dir, f[0])
+		full, errFull := readSysfsUint(dir, f[1])
+		rate, errRate := readSysfsUint(dir, f[2])
+		if errNow != nil || errFull != nil || errRate != nil || rate == 0 {
+			continue
+		}
+		remaining := now
+		if charging {
+			if full < now {
+				return 0
+			}
+			remaining = full - now
+		}
+		return uint64(float64(remaining) / float64(rate) * 3600)
+	}
+	return 0
+}
+
+// readSysfs returns the trimmed contents of a sysfs attribute, or "" if it
+// can't be read.
+func readSysfs(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readSysfsUint(dir, name string) (uint64, error) {
+	return strconv.ParseUint(readSysfs(dir, name), 10, 64)
+}
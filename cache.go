@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// statsCache memoizes getStats for a short TTL so concurrent pollers share
+// one collection. Simultaneous misses for the same section set wait on a
+// single getStats call; the mutex only guards the maps, so misses for
+// different sets (and collection itself) run concurrently.
+//
+// Entries are kept per section set; a fresh full collection also serves
+// requests for any subset. Expired entries are dropped on every miss and at
+// most maxCacheEntries are held, so clients cycling through ?fields=
+// combinations can't grow the cache without bound. Cached *Stats values are
+// shared between callers and must not be modified.
+type statsCache struct {
+	cfg     *Config
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu       sync.Mutex
+	entries  map[string]cacheEntry
+	inflight map[string]*cacheCall
+}
+
+// maxCacheEntries caps the section sets cached at once. Past it, fresh
+// subsets are still collected and returned, just not kept.
+const maxCacheEntries = 32
+
+type cacheEntry struct {
+	stats *Stats
+	at    time.Time
+}
+
+// cacheCall is a collection in progress; done is closed once stats and err
+// are set.
+type cacheCall struct {
+	done  chan struct{}
+	stats *Stats
+	err   error
+}
+
+func newStatsCache(cfg *Config) *statsCache {
+	return &statsCache{
+		cfg:      cfg,
+		ttl:      cfg.StatsCacheTTL,
+		timeout:  cfg.StatsTimeout,
+		entries:  make(map[string]cacheEntry),
+		inflight: make(map[string]*cacheCall),
+	}
+}
+
+// get returns the cached stats for sections (nil for all) if they are
+// younger than the TTL, and collects fresh ones otherwise. A zero TTL
+// disables caching. Collection is bounded by the configured stats timeout
+// and carries on if ctx ends first, since other callers may be waiting on
+// it; get itself returns as soon as ctx is done.
+func (c *statsCache) get(ctx context.Context, sections sectionSet) (*Stats, error) {
+	if c.ttl <= 0 {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		return getStats(ctx, c.cfg, sections)
+	}
+
+	key := sections.key()
+	c.mu.Lock()
+	for _, k := range []string{"", key} {
+		if e, ok := c.entries[k]; ok && time.Since(e.at) < c.ttl {
+			c.mu.Unlock()
+			return e.stats, nil
+		}
+	}
+	for k, e := range c.entries {
+		if time.Since(e.at) >= c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	call, ok := c.inflight[key]
+	if !ok {
+		call = &cacheCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		go c.collect(ctx, key, sections, call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.stats, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *statsCache) collect(ctx context.Context, key string, sections sectionSet, call *cacheCall) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+	call.stats, call.err = getStats(ctx, c.cfg, sections)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	// The full set is always kept since it serves every subset.
+	_, cached := c.entries[key]
+	if call.err == nil && (cached || key == "" || len(c.entries) < maxCacheEntries) {
+		c.entries[key] = cacheEntry{stats: call.stats, at: time.Now()}
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupLimits is set in main when CONTAINER_AWARE is on (or "auto" inside
+// a container). collectMemory and collectCPU then report figures scoped to
+// the container's cgroup for whichever resources it actually limits.
+var cgroupLimits *cgroup
+
+// cgroup reads one process's memory and CPU controllers, under either the
+// unified v2 hierarchy or the per-controller v1 ones.
+type cgroup struct {
+	v2     bool
+	memDir string
+	cpuDir string // cpu.max (v2) or cpu.cfs_* (v1)
+	accDir string // cpu.stat (v2) or cpuacct.usage (v1)
+
+	mu        sync.Mutex
+	lastUsage time.Duration
+	lastTime  time.Time
+	lastPct   float64
+}
+
+// containerAware resolves CONTAINER_AWARE: "true" and "false" force it,
+// "auto" enables it only when running in a container.
+func containerAware(mode string) bool {
+	if mode == "auto" {
+		return inContainer()
+	}
+	on, _ := strconv.ParseBool(mode)
+	return on
+}
+
+// inContainer guesses whether we run in a container from the marker files
+// Docker and Podman create, or from our cgroup path.
+func inContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return false
+	}
+	for _, runtime := range []string{"docker", "kubepods", "containerd", "lxc", "libpod"} {
+		if strings.Contains(string(data), runtime) {
+			return true
+		}
+	}
+	return false
+}
+
+// openCgroup locates this process's cgroup. Inside a container with its
+// own cgroup namespace the paths in /proc/self/cgroup are "/", so the
+// controllers sit directly under cgroupRoot.
+func openCgroup() *cgroup {
+	paths := make(map[string]string) // controller ("" for v2) -> path
+	if f, err := os.Open("/proc/self/cgroup"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// hierarchy-ID:controller-list:path
+			parts := strings.SplitN(scanner.Text(), ":", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			for _, controller := range strings.Split(parts[1], ",") {
+				paths[controller] = parts[2]
+			}
+		}
+		f.Close()
+	}
+	dir := func(controller string) string {
+		base := filepath.Join(cgroupRoot, controller)
+		if p := filepath.Join(base, paths[controller]); fileExists(p) {
+			return p
+		}
+		return base
+	}
+
+	if fileExists(filepath.Join(cgroupRoot, "cgroup.controllers")) {
+		d := dir("")
+		return &cgroup{v2: true, memDir: d, cpuDir: d, accDir: d}
+	}
+	return &cgroup{memDir: dir("memory"), cpuDir: dir("cpu"), accDir: dir("cpuacct")}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// memory returns the cgroup's memory limit and usage, not counting
+// reclaimable page cache (as docker stats does). ok is false when there is
+// no limit below hostTotal, in which case host figures are more useful.
+func (c *cgroup) memory(hostTotal uint64) (limit, used uint64, ok bool) {
+	limitFile, usageFile, inactiveKey := "memory.limit_in_bytes", "memory.usage_in_bytes", "total_inactive_file"
+	if c.v2 {
+		limitFile, usageFile, inactiveKey = "memory.max", "memory.current", "inactive_file"
+	}
+	// v2 writes "max" for no limit; v1 a huge page-aligned number.
+	limit, err := readSysfsUint(c.memDir, limitFile)
+	if err != nil || limit == 0 || limit >= hostTotal {
+		return 0, 0, false
+	}
+	usage, err := readSysfsUint(c.memDir, usageFile)
+	if err != nil {
+		return 0, 0, false
+	}
+	if inactive, ok := cgroupStat(filepath.Join(c.memDir, "memory.stat"), inactiveKey); ok && inactive < usage {
+		usage -= inactive
+	}
+	return limit, usage, true
+}
+
+// cpuLimit returns the cgroup's CPU quota in cores, or 0 if unlimited.
+func (c *cgroup) cpuLimit() float64 {
+	var quota, period float64
+	if c.v2 {
+		// "quota period", with quota "max" when unlimited
+		fields := strings.Fields(readSysfs(c.cpuDir, "cpu.max"))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0
+		}
+		quota, _ = strconv.ParseFloat(fields[0], 64)
+		period, _ = strconv.ParseFloat(fields[1], 64)
+	} else {
+		// cfs_quota_us is -1 when unlimited
+		quota, _ = strconv.ParseFloat(readSysfs(c.cpuDir, "cpu.cfs_quota_us"), 64)
+		period, _ = strconv.ParseFloat(readSysfs(c.cpuDir, "cpu.cfs_period_us"), 64)
+	}
+	if quota <= 0 || period <= 0 {
+		return 0
+	}
+	return quota / period
+}
+
+// cpuUsage returns the cgroup's total CPU time.
+func (c *cgroup) cpuUsage() (time.Duration, bool) {
+	if c.v2 {
+		usec, ok := cgroupStat(filepath.Join(c.accDir, "cpu.stat"), "usage_usec")
+		return time.Duration(usec) * time.Microsecond, ok
+	}
+	nsec, err := readSysfsUint(c.accDir, "cpuacct.usage")
+	return time.Duration(nsec), err == nil
+}
+
+// cpuPercent returns the cgroup's CPU usage as a percentage of its quota
+// of limit cores, averaged since the previous call. The first call only
+// records a baseline, so ok is false and the caller should use host CPU.
+// Calls closer together than minCPUDelta reuse the last result, since
+// concurrent collections would otherwise divide by almost nothing.
+func (c *cgroup) cpuPercent(limit float64) (float64, bool) {
+	const minCPUDelta = 100 * time.Millisecond
+	usage, ok := c.cpuUsage()
+	if !ok {
+		return 0, false
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastTime.IsZero() {
+		c.lastUsage, c.lastTime = usage, now
+		return 0, false
+	}
+	elapsed := now.Sub(c.lastTime)
+	if elapsed < minCPUDelta {
+		return c.lastPct, true
+	}
+	pct := float64(usage-c.lastUsage) / (float64(elapsed) * limit) * 100
+	c.lastPct = min(max(pct, 0), 100)
+	c.lastUsage, c.lastTime = usage, now
+	return c.lastPct, true
+}
+
+// cgroupStat reads one "key value" line from a cgroup stat file.
+func cgroupStat(path, key string) (uint64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), " ")
+		if ok && k == key {
+			n, err := strconv.ParseUint(v, 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
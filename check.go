@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// runCheck collects stats once and prints them, followed by one line per
+// failed collector, for verifying a host before deploying. It returns the
+// process exit code: 0 only if every collector succeeded.
+func runCheck(cfg *Config) int {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.StatsTimeout)
+	defer cancel()
+	stats, err := getStats(ctx, cfg, nil)
+	if err != nil {
+		fmt.Printf("FAIL: %v\n", err)
+		return 1
+	}
+
+	out, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		fmt.Printf("FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	names := make([]string, 0, len(stats.Errors))
+	for name := range stats.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("FAIL %s: %s\n", name, stats.Errors[name])
+	}
+	// /api/connections isn't a collector, but it is the likeliest endpoint
+	// to need privileges, so report it without failing the check.
+	if _, err := tcpConnections(); err != nil {
+		fmt.Printf("WARN connections: %v\n", err)
+	}
+	if len(names) > 0 {
+		return 1
+	}
+	enabled := 0
+	for _, c := range collectors {
+		if cfg.Sections.has(c.name) {
+			enabled++
+		}
+	}
+	fmt.Printf("OK: all %d collectors succeeded\n", enabled)
+	return 0
+}
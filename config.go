@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPrecision caps PRECISION well within float64's ~15 significant digits.
+const maxPrecision = 6
+
+// Config holds the runtime settings. Defaults are overridden by the optional
+// JSON config file, which is in turn overridden by environment variables.
+type Config struct {
+	Port            string
+	BindAddr        string // host or IP to listen on; empty means all interfaces
+	UnixSocket      string // serve on this Unix socket path instead of TCP
+	GRPCPort        string // serve the gRPC API in dashboardpb/dashboard.proto on this port; empty disables
+	NetInterface    string // limit Stats.Network to this interface; empty sums all
+	DiskPath        string
+	DiskPaths       []string // explicit mountpoints for Stats.Disks; empty auto-discovers
+	DiskExclude     []string // globs on mountpoint or fstype skipped by disk auto-discovery; set DISK_EXCLUDE="," to clear
+	ShutdownTimeout time.Duration
+	SampleInterval  time.Duration
+	SamplerMode     string // "always", or "on-demand": only while /ws or /api/stream clients are connected
+	// CPUSampleInterval is how long each CPU measurement blocks. Zero uses
+	// gopsutil's non-blocking mode, which reports usage since the previous
+	// measurement instead of sampling a fresh window.
+	CPUSampleInterval time.Duration
+	// CPUPrewarmInterval, when non-zero, samples CPU continuously in the
+	// background over windows of this length, and requests use the latest
+	// sample instead of blocking for CPUSampleInterval.
+	CPUPrewarmInterval   time.Duration
+	StatsCacheTTL        time.Duration
+	StatsTimeout         time.Duration // upper bound on a single stats collection
+	CollectorRetries     int           // extra attempts for a collector that fails outright
+	CollectorRetryDelay  time.Duration // wait before the first retry; doubles for each one after
+	TLSCertFile          string
+	TLSKeyFile           string
+	H2C                  bool // accept cleartext HTTP/2 alongside HTTP/1.1
+	AuthUser             string
+	AuthPass             string
+	AllowedOrigin        string
+	HistorySize          int
+	DBPath               string // SQLite file for persisted history; empty keeps it in memory only
+	LogRequests          bool
+	LogFormat            string  // "text" or "json"
+	RateLimit            float64 // requests/sec per client IP; 0 disables
+	TrustProxy           bool    // take client IPs from X-Real-IP / X-Forwarded-For
+	PrettyJSON           bool    // indent JSON responses unless ?pretty=0
+	ContainerAware       string  // "auto", "true" or "false": report cgroup-scoped memory and CPU
+	Theme                string  // dashboard look: "dark" or a static/themes/<name>.css
+	DashboardTitle       string  // browser tab title, e.g. the hostname to tell instances apart
+	ServeStatic          bool    // serve the embedded dashboard; false leaves only the API
+	StaticMaxAge         int     // Cache-Control max-age for static assets, in seconds
+	WebhookURL           string
+	PushgatewayURL       string
+	MQTTBroker           string  // publish samples to this broker, e.g. tcp://broker:1883; empty disables
+	MQTTTopic            string  // defaults to dashboard/<hostname>/stats
+	MetricsPrefix        string  // prepended to every metric name on /metrics and pushes
+	CPUAlertPct          float64 // alert thresholds in percent; 0 disables
+	MemAlertPct          float64
+	DiskAlertPct         float64
+	DiskMinFreeBytes     uint64     // alert when DISK_PATH has less free space than this; 0 disables
+	TempWarnC            float64    // any sensor above this many °C sets ThermalWarning; 0 uses only sensor thresholds
+	AnomalySigma         float64    // flag metrics this many standard deviations from their recent mean; 0 disables
+	GPUEnabled           bool       // query NVIDIA GPUs via nvidia-smi
+	Sections             sectionSet // collectors getStats may run; nil runs all of them
+	Precision            int        // decimal places for percentages and rates; load averages get one more
+	Peers                []string   // /api/stats URLs polled in hub mode
+	PeersFile            string     // more peers, one URL per line; reloaded on change or SIGHUP
+	MaxConcurrentSamples int        // CPU samples allowed to run at once
+	WatchProcess         string     // process name to report in Stats.WatchedProcess
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Port:                 "3000",
+		DiskPath:             "/",
+		ShutdownTimeout:      10 * time.Second,
+		SampleInterval:       2 * time.Second,
+		CPUSampleInterval:    time.Second,
+		StatsCacheTTL:        time.Second,
+		DiskExclude:          []string{"overlay", "/snap/*", "/var/lib/docker/*"},
+		StatsTimeout:         5 * time.Second,
+		CollectorRetries:     2,
+		CollectorRetryDelay:  50 * time.Millisecond,
+		HistorySize:          300,
+		Precision:            1,
+		LogFormat:            "text",
+		ContainerAware:       "auto",
+		Theme:                defaultTheme,
+		DashboardTitle:       "Server Dashboard",
+		ServeStatic:          true,
+		SamplerMode:          "always",
+		StaticMaxAge:         3600,
+		MetricsPrefix:        "dashboard",
+		AnomalySigma:         3,
+		MaxConcurrentSamples: 1,
+	}
+}
+
+// loadConfig builds the configuration from the defaults, the JSON file at
+// path (if path is non-empty) and the environment, then validates it.
+func loadConfig(path string) (*Config, error) {
+	c := defaultConfig()
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	c.loadEnv()
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadEnv overrides settings with any environment variables that are set.
+func (c *Config) loadEnv() {
+	c.Port = getEnv("PORT", c.Port)
+	c.BindAddr = getEnv("BIND_ADDR", c.BindAddr)
+	c.UnixSocket = getEnv("UNIX_SOCKET", c.UnixSocket)
+	c.GRPCPort = getEnv("GRPC_PORT", c.GRPCPort)
+	c.NetInterface = getEnv("NET_INTERFACE", c.NetInterface)
+	c.DiskPath = getEnv("DISK_PATH", c.DiskPath)
+	c.DiskPaths = getEnvList("DISK_PATHS", c.DiskPaths)
+	c.DiskExclude = getEnvList("DISK_EXCLUDE", c.DiskExclude)
+	c.ShutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+	c.SampleInterval = getEnvDuration("SAMPLE_INTERVAL", c.SampleInterval)
+	c.SamplerMode = getEnv("SAMPLER_MODE", c.SamplerMode)
+	c.CPUSampleInterval = getEnvDuration("CPU_SAMPLE_INTERVAL", c.CPUSampleInterval)
+	c.CPUPrewarmInterval = getEnvDuration("CPU_PREWARM_INTERVAL", c.CPUPrewarmInterval)
+	c.StatsCacheTTL = getEnvDuration("STATS_CACHE_TTL", c.StatsCacheTTL)
+	c.StatsTimeout = getEnvDuration("STATS_TIMEOUT", c.StatsTimeout)
+	c.CollectorRetries = getEnvNonNegInt("COLLECTOR_RETRIES", c.CollectorRetries)
+	c.CollectorRetryDelay = getEnvDuration("COLLECTOR_RETRY_DELAY", c.CollectorRetryDelay)
+	c.TLSCertFile = getEnv("TLS_CERT_FILE", c.TLSCertFile)
+	c.TLSKeyFile = getEnv("TLS_KEY_FILE", c.TLSKeyFile)
+	c.H2C = getEnvBool("H2C", c.H2C)
+	c.AuthUser = getEnv("AUTH_USER", c.AuthUser)
+	c.AuthPass = getEnv("AUTH_PASS", c.AuthPass)
+	c.AllowedOrigin = getEnv("ALLOWED_ORIGIN", c.AllowedOrigin)
+	c.HistorySize = getEnvInt("HISTORY_SIZE", c.HistorySize)
+	c.DBPath = getEnv("DB_PATH", c.DBPath)
+	c.LogRequests = getEnvBool("LOG_REQUESTS", c.LogRequests)
+	c.LogFormat = getEnv("LOG_FORMAT", c.LogFormat)
+	c.RateLimit = getEnvFloat("RATE_LIMIT", c.RateLimit)
+	c.TrustProxy = getEnvBool("TRUST_PROXY", c.TrustProxy)
+	c.PrettyJSON = getEnvBool("PRETTY_JSON", c.PrettyJSON)
+	c.ContainerAware = getEnv("CONTAINER_AWARE", c.ContainerAware)
+	c.Theme = getEnv("THEME", c.Theme)
+	c.DashboardTitle = getEnv("DASHBOARD_TITLE", c.DashboardTitle)
+	c.ServeStatic = getEnvBool("SERVE_STATIC", c.ServeStatic)
+	c.StaticMaxAge = getEnvNonNegInt("STATIC_MAX_AGE", c.StaticMaxAge)
+	c.WebhookURL = getEnv("WEBHOOK_URL", c.WebhookURL)
+	c.PushgatewayURL = getEnv("PUSHGATEWAY_URL", c.PushgatewayURL)
+	c.MQTTBroker = getEnv("MQTT_BROKER", c.MQTTBroker)
+	c.MQTTTopic = getEnv("MQTT_TOPIC", c.MQTTTopic)
+	c.MetricsPrefix = getEnv("METRICS_PREFIX", c.MetricsPrefix)
+	c.CPUAlertPct = getEnvFloat("CPU_ALERT_PCT", c.CPUAlertPct)
+	c.MemAlertPct = getEnvFloat("MEM_ALERT_PCT", c.MemAlertPct)
+	c.DiskAlertPct = getEnvFloat("DISK_ALERT_PCT", c.DiskAlertPct)
+	c.DiskMinFreeBytes = getEnvUint64("DISK_MIN_FREE_BYTES", c.DiskMinFreeBytes)
+	c.TempWarnC = getEnvFloat("TEMP_WARN_C", c.TempWarnC)
+	c.AnomalySigma = getEnvFloat("ANOMALY_SIGMA", c.AnomalySigma)
+	c.GPUEnabled = getEnvBool("GPU_ENABLED", c.GPUEnabled)
+	if v := os.Getenv("SECTIONS"); v != "" {
+		c.Sections = parseSections(v)
+	}
+	c.Precision = getEnvNonNegInt("PRECISION", c.Precision)
+	c.Peers = getEnvList("PEERS", c.Peers)
+	c.PeersFile = getEnv("PEERS_FILE", c.PeersFile)
+	c.MaxConcurrentSamples = getEnvInt("MAX_CONCURRENT_SAMPLES", c.MaxConcurrentSamples)
+	c.WatchProcess = getEnv("WATCH_PROCESS", c.WatchProcess)
+}
+
+// fileConfig is the layout of the JSON config file. Durations are strings
+// in time.ParseDuration format (e.g. "500ms"); omitted fields keep their
+// current values.
+type fileConfig struct {
+	Port              int      `json:"port"`
+	BindAddr          string   `json:"bind_addr"`
+	UnixSocket        string   `json:"unix_socket"`
+	NetInterface      string   `json:"net_interface"`
+	DiskPath          string   `json:"disk_path"`
+	DiskPaths         []string `json:"disk_paths"`
+	SampleInterval    string   `json:"sample_interval"`
+	CPUSampleInterval string   `json:"cpu_sample_interval"`
+	AuthUser          string   `json:"auth_user"`
+	AuthPass          string   `json:"auth_pass"`
+	WebhookURL        string   `json:"webhook_url"`
+	CPUAlertPct       float64  `json:"cpu_alert_pct"`
+	MemAlertPct       float64  `json:"mem_alert_pct"`
+	DiskAlertPct      float64  `json:"disk_alert_pct"`
+	DiskMinFreeBytes  uint64   `json:"disk_min_free_bytes"`
+	Peers             []string `json:"peers"`
+	PeersFile         string   `json:"peers_file"`
+}
+
+// loadFile applies the settings from the JSON config file at path.
+// Unknown keys are rejected so typos don't go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	port, err := strconv.Atoi(c.Port)
+	if err != nil {
+		return fmt.Errorf("invalid default port %q", c.Port)
+	}
+	fc := fileConfig{
+		Port:              port,
+		BindAddr:          c.BindAddr,
+		UnixSocket:        c.UnixSocket,
+		NetInterface:      c.NetInterface,
+		DiskPath:          c.DiskPath,
+		DiskPaths:         c.DiskPaths,
+		SampleInterval:    c.SampleInterval.String(),
+		CPUSampleInterval: c.CPUSampleInterval.String(),
+		AuthUser:          c.AuthUser,
+		AuthPass:          c.AuthPass,
+		WebhookURL:        c.WebhookURL,
+		CPUAlertPct:       c.CPUAlertPct,
+		MemAlertPct:       c.MemAlertPct,
+		DiskAlertPct:      c.DiskAlertPct,
+		DiskMinFreeBytes:  c.DiskMinFreeBytes,
+		Peers:             c.Peers,
+		PeersFile:         c.PeersFile,
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fc); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	sampleInterval, err := time.ParseDuration(fc.SampleInterval)
+	if err != nil {
+		return fmt.Errorf("config file %s: sample_interval: %w", path, err)
+	}
+	cpuSampleInterval, err := time.ParseDuration(fc.CPUSampleInterval)
+	if err != nil {
+		return fmt.Errorf("config file %s: cpu_sample_interval: %w", path, err)
+	}
+
+	c.Port = strconv.Itoa(fc.Port)
+	c.BindAddr = fc.BindAddr
+	c.UnixSocket = fc.UnixSocket
+	c.NetInterface = fc.NetInterface
+	c.DiskPath = fc.DiskPath
+	c.DiskPaths = fc.DiskPaths
+	c.SampleInterval = sampleInterval
+	c.CPUSampleInterval = cpuSampleInterval
+	c.AuthUser = fc.AuthUser
+	c.AuthPass = fc.AuthPass
+	c.WebhookURL = fc.WebhookURL
+	c.CPUAlertPct = fc.CPUAlertPct
+	c.MemAlertPct = fc.MemAlertPct
+	c.DiskAlertPct = fc.DiskAlertPct
+	c.DiskMinFreeBytes = fc.DiskMinFreeBytes
+	c.Peers = fc.Peers
+	c.PeersFile = fc.PeersFile
+	return nil
+}
+
+// listenAddr is the TCP address to listen on, as host:port.
+func (c *Config) listenAddr() string {
+	return net.JoinHostPort(c.BindAddr, c.Port)
+}
+
+// validate reports settings that are malformed or can't work together.
+func (c *Config) validate() error {
+	// Checked before the listen address, whose error for a bad port
+	// ("unknown port") doesn't say which setting is wrong.
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid PORT value: %q (must be a number from 1 to 65535)", c.Port)
+	}
+	if _, err := net.ResolveTCPAddr("tcp", c.listenAddr()); err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", c.listenAddr(), err)
+	}
+	if c.GRPCPort != "" {
+		if _, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(c.BindAddr, c.GRPCPort)); err != nil {
+			return fmt.Errorf("invalid gRPC port %q: %w", c.GRPCPort, err)
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.H2C && c.TLSCertFile != "" {
+		return errors.New("H2C is for plaintext listeners; TLS negotiates HTTP/2 by itself")
+	}
+	if (c.AuthUser == "") != (c.AuthPass == "") {
+		return errors.New("AUTH_USER and AUTH_PASS must be set together")
+	}
+	if c.GRPCPort != "" && c.AuthUser != "" && c.TLSCertFile == "" {
+		return errors.New("GRPC_PORT with AUTH_USER needs TLS_CERT_FILE and TLS_KEY_FILE, or the credentials cross the network in the clear")
+	}
+	if c.SampleInterval <= 0 {
+		return fmt.Errorf("sample interval must be positive, got %s", c.SampleInterval)
+	}
+	if c.StatsTimeout <= 0 {
+		return fmt.Errorf("stats timeout must be positive, got %s", c.StatsTimeout)
+	}
+	if c.Sections != nil && len(c.Sections) == 0 {
+		return errors.New("SECTIONS names no known collectors; see the collector names in stats.go")
+	}
+	if c.CollectorRetries > maxCollectorRetries {
+		return fmt.Errorf("collector retries must be at most %d, got %d", maxCollectorRetries, c.CollectorRetries)
+	}
+	if c.CollectorRetryDelay > maxRetryDelay {
+		return fmt.Errorf("collector retry delay must be at most %s, got %s", maxRetryDelay, c.CollectorRetryDelay)
+	}
+	if !isMetricName(c.MetricsPrefix) {
+		return fmt.Errorf("invalid metrics prefix %q: must match [a-zA-Z_:][a-zA-Z0-9_:]*", c.MetricsPrefix)
+	}
+	if c.PushgatewayURL != "" && !isHTTPURL(c.PushgatewayURL) {
+		return fmt.Errorf("invalid Pushgateway URL %q", c.PushgatewayURL)
+	}
+	if c.MQTTBroker != "" && !isMQTTURL(c.MQTTBroker) {
+		// not quoted back: broker URLs often carry a password
+		return errors.New("invalid MQTT broker URL: want scheme://host:port with scheme tcp, mqtt, ssl, tls, mqtts, ws or wss")
+	}
+	for _, pattern := range c.DiskExclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid disk exclude pattern %q: %w", pattern, err)
+		}
+	}
+	for _, p := range c.Peers {
+		if !isHTTPURL(p) {
+			return fmt.Errorf("invalid peer URL %q", p)
+		}
+	}
+	if _, err := strconv.ParseBool(c.ContainerAware); err != nil && c.ContainerAware != "auto" {
+		return fmt.Errorf(`container aware must be "auto", "true" or "false", got %q`, c.ContainerAware)
+	}
+	if !themeExists(c.Theme) {
+		return fmt.Errorf("unknown theme %q", c.Theme)
+	}
+	if c.SamplerMode != "always" && c.SamplerMode != "on-demand" {
+		return fmt.Errorf(`sampler mode must be "always" or "on-demand", got %q`, c.SamplerMode)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf(`log format must be "text" or "json", got %q`, c.LogFormat)
+	}
+	if c.Precision > maxPrecision {
+		return fmt.Errorf("precision must be at most %d, got %d", maxPrecision, c.Precision)
+	}
+	if c.CPUSampleInterval < 0 {
+		return fmt.Errorf("CPU sample interval must not be negative, got %s", c.CPUSampleInterval)
+	}
+	for _, t := range []struct {
+		name  string
+		value float64
+	}{
+		{"CPU alert threshold", c.CPUAlertPct},
+		{"memory alert threshold", c.MemAlertPct},
+		{"disk alert threshold", c.DiskAlertPct},
+	} {
+		if t.value < 0 || t.value > 100 {
+			return fmt.Errorf("%s must be between 0 and 100, got %g", t.name, t.value)
+		}
+	}
+	if c.AnomalySigma < 0 {
+		return fmt.Errorf("anomaly sigma must not be negative, got %g", c.AnomalySigma)
+	}
+	if c.TempWarnC < 0 {
+		return fmt.Errorf("temperature warning threshold must not be negative, got %g", c.TempWarnC)
+	}
+	return nil
+}
+
+// isHTTPURL reports whether s is an absolute http or https URL.
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isMetricName reports whether s is a valid Prometheus metric name, which
+// keeps it valid once "_cpu_percent" and the like are appended.
+func isMetricName(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == '_' || r == ':' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// getEnv returns the value of the environment variable key, or fallback if
+// it is unset or empty.
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// getEnvList splits key on commas, trimming whitespace and dropping empty
+// entries. Unset values use fallback.
+func getEnvList(key string, fallback []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvBool parses key with strconv.ParseBool (so "1", "true", "false"...).
+// Unset values use fallback; invalid ones log a warning and use fallback.
+func getEnvBool(key string, fallback bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return b
+}
+
+// getEnvInt parses key as a positive integer. Unset values use fallback;
+// invalid ones log a warning and use fallback.
+func getEnvInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return n
+}
+
+// getEnvNonNegInt is like getEnvInt but also accepts zero.
+func getEnvNonNegInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return n
+}
+
+// getEnvFloat parses key as a non-negative number. Unset values use
+// fallback; invalid ones log a warning and use fallback.
+func getEnvFloat(key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return f
+}
+
+// getEnvUint64 is getEnvNonNegInt for byte counts, which can exceed int
+// on 32-bit platforms.
+func getEnvUint64(key string, fallback uint64) uint64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return n
+}
+
+// getEnvDuration parses key with time.ParseDuration. Unset values use
+// fallback; invalid or negative ones log a warning and use fallback.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", fallback.String())
+		return fallback
+	}
+	return d
+}
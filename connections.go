@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+type ConnectionStats struct {
+	Total  int            `json:"total"`
+	States map[string]int `json:"states"` // e.g. ESTABLISHED, TIME_WAIT
+}
+
+// tcpConnections counts TCP connections by state.
+func tcpConnections() (*ConnectionStats, error) {
+	conns, err := net.Connections("tcp")
+	if err != nil {
+		return nil, privilegeError(err, "TCP connection stats")
+	}
+	stats := &ConnectionStats{Total: len(conns), States: make(map[string]int)}
+	for _, c := range conns {
+		stats.States[c.Status]++
+	}
+	return stats, nil
+}
+
+// connectionsHandler serves TCP connection counts. Enumerating connections
+// is costly and may need privileges, so it lives outside /api/stats.
+func connectionsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := tcpConnections()
+	if errors.Is(err, fs.ErrPermission) {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, r, http.StatusOK, stats)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+)
+
+// cpuSamples limits how many blocking CPU samples run at once. main sizes
+// it from MAX_CONCURRENT_SAMPLES before serving.
+var cpuSamples = newSampleGate(1)
+
+// sampleGate runs at most cap(sem) CPU samples concurrently. A caller that
+// finds no free slot shares the result of an in-flight sample with the same
+// interval instead of starting another one.
+type sampleGate struct {
+	sem chan struct{}
+
+	mu       sync.Mutex
+	inflight map[time.Duration]*cpuSample // latest running sample per interval
+}
+
+type cpuSample struct {
+	done    chan struct{} // closed once perCore and err are set
+	perCore []float64
+	err     error
+}
+
+func newSampleGate(n int) *sampleGate {
+	return &sampleGate{
+		sem:      make(chan struct{}, n),
+		inflight: make(map[time.Duration]*cpuSample),
+	}
+}
+
+// percent returns per-core usage sampled over interval, like cpu.Percent.
+// The returned slice may be shared with other callers and must not be
+// modified.
+func (g *sampleGate) percent(ctx context.Context, interval time.Duration) ([]float64, error) {
+	for {
+		g.mu.Lock()
+		select {
+		case g.sem <- struct{}{}:
+			return g.sample(ctx, interval) // unlocks g.mu
+		default:
+		}
+		shared, ok := g.inflight[interval]
+		g.mu.Unlock()
+
+		if !ok {
+			// Every slot is busy with other intervals; wait for one.
+			select {
+			case g.sem <- struct{}{}:
+				g.mu.Lock()
+				return g.sample(ctx, interval)
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		select {
+		case <-shared.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// A sample abandoned by its own caller's deadline says nothing
+		// about ours, so try again rather than passing that error on.
+		if isContextErr(shared.err) && ctx.Err() == nil {
+			continue
+		}
+		return shared.perCore, shared.err
+	}
+}
+
+// sample takes a slot-holding sample and publishes it to waiting callers.
+// It must be called with g.mu held and a slot acquired.
+func (g *sampleGate) sample(ctx context.Context, interval time.Duration) ([]float64, error) {
+	s := &cpuSample{done: make(chan struct{})}
+	g.inflight[interval] = s
+	g.mu.Unlock()
+
+	s.perCore, s.err = cpu.PercentWithContext(ctx, interval, true)
+
+	g.mu.Lock()
+	if g.inflight[interval] == s {
+		delete(g.inflight, interval)
+	}
+	g.mu.Unlock()
+	<-g.sem
+	close(s.done)
+	return s.perCore, s.err
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// cpuPrewarm holds the latest background CPU sample when
+// CPU_PREWARM_INTERVAL is set, so requests don't have to block sampling.
+var cpuPrewarm = &prewarmedCPU{}
+
+type prewarmedCPU struct {
+	mu       sync.RWMutex
+	interval time.Duration
+	perCore  []float64
+	at       time.Time
+}
+
+// run samples back to back, each sample covering interval, until ctx is
+// cancelled.
+func (p *prewarmedCPU) run(ctx context.Context, interval time.Duration) {
+	p.mu.Lock()
+	p.interval = interval
+	p.mu.Unlock()
+	for {
+		perCore, err := cpu.PercentWithContext(ctx, interval, true)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("CPU prewarm failed", "err", err)
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		p.mu.Lock()
+		p.perCore, p.at = perCore, time.Now()
+		p.mu.Unlock()
+	}
+}
+
+// latest returns the most recent sample, unless there is none yet or it is
+// so old that the background loop seems stuck. The slice must not be
+// modified.
+func (p *prewarmedCPU) latest() ([]float64, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.perCore == nil || time.Since(p.at) > 2*p.interval {
+		return nil, false
+	}
+	return p.perCore, true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+)
+
+// CPUTimesStats breaks CPU time down by state, in percent of all CPU time
+// since the previous sample. High Iowait means waiting on disks; high
+// Steal means the hypervisor is giving our cycles to other guests.
+type CPUTimesStats struct {
+	User   float64 `json:"user"`
+	System float64 `json:"system"`
+	Idle   float64 `json:"idle"`
+	Iowait float64 `json:"iowait"`
+	Steal  float64 `json:"steal"`
+}
+
+// cpuTimesTracker turns cumulative CPU times into percentages of the delta
+// since the previous call.
+type cpuTimesTracker struct {
+	mu   sync.Mutex
+	prev *cpu.TimesStat
+	last *CPUTimesStats
+}
+
+var cpuTimes cpuTimesTracker
+
+// percent returns the breakdown since the previous call, or nil on the
+// first call. Calls too close together for the counters (which tick in
+// hundredths of a second) to have moved repeat the last result.
+func (t *cpuTimesTracker) percent(ctx context.Context, places int) (*CPUTimesStats, error) {
+	times, err := cpu.TimesWithContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(times) == 0 {
+		return nil, nil
+	}
+	cur := times[0]
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev := t.prev
+	if prev == nil {
+		t.prev = &cur
+		return nil, nil
+	}
+	total := cpuTimesTotal(cur) - cpuTimesTotal(*prev)
+	if total <= 0 {
+		return t.last, nil
+	}
+	pct := func(cur, prev float64) float64 {
+		return round(max(cur-prev, 0)/total*100, places)
+	}
+	t.last = &CPUTimesStats{
+		User:   pct(cur.User+cur.Nice, prev.User+prev.Nice),
+		System: pct(cur.System+cur.Irq+cur.Softirq, prev.System+prev.Irq+prev.Softirq),
+		Idle:   pct(cur.Idle, prev.Idle),
+		Iowait: pct(cur.Iowait, prev.Iowait),
+		Steal:  pct(cur.Steal, prev.Steal),
+	}
+	t.prev = &cur
+	return t.last, nil
+}
+
+// cpuTimesTotal sums the states that make up all CPU time. Guest time is
+// left out: Linux already counts it in User and Nice.
+func cpuTimesTotal(t cpu.TimesStat) float64 {
+	return t.User + t.Nice + t.System + t.Irq + t.Softirq + t.Idle + t.Iowait + t.Steal
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// csvColumn is one scalar field of the CSV export, filled in by collector.
+// Columns of a disabled or failed collector are left blank rather than 0.
+type csvColumn struct {
+	name      string
+	collector string // "" for fields every sample has
+	value     func(*Stats) string
+}
+
+func csvFloat(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+func csvUint(v uint64) string   { return strconv.FormatUint(v, 10) }
+
+// csvColumns lists the exported fields. Only scalars are included so every
+// export has the same columns regardless of how many disks or interfaces
+// the host has.
+var csvColumns = []csvColumn{
+	{"timestamp", "", func(s *Stats) string { return s.Timestamp.Format(time.RFC3339) }},
+	{"hostname", "", func(s *Stats) string { return s.Hostname }},
+	{"cpu_percent", "cpu", func(s *Stats) string { return csvFloat(s.CPUPercent) }},
+	{"memory_total", "memory", func(s *Stats) string { return csvUint(s.Memory.Total) }},
+	{"memory_used", "memory", func(s *Stats) string { return csvUint(s.Memory.Used) }},
+	{"memory_percent", "memory", func(s *Stats) string { return csvFloat(s.Memory.Percent) }},
+	{"swap_total", "swap", func(s *Stats) string { return csvUint(s.Swap.Total) }},
+	{"swap_used", "swap", func(s *Stats) string { return csvUint(s.Swap.Used) }},
+	{"swap_percent", "swap", func(s *Stats) string { return csvFloat(s.Swap.Percent) }},
+	{"disk_mountpoint", "disk", func(s *Stats) string { return s.Disk.Mountpoint }},
+	{"disk_total", "disk", func(s *Stats) string { return csvUint(s.Disk.Total) }},
+	{"disk_used", "disk", func(s *Stats) string { return csvUint(s.Disk.Used) }},
+	{"disk_percent", "disk", func(s *Stats) string { return csvFloat(s.Disk.Percent) }},
+	{"net_bytes_sent", "network", func(s *Stats) string { return csvUint(s.Network.BytesSent) }},
+	{"net_bytes_recv", "network", func(s *Stats) string { return csvUint(s.Network.BytesRecv) }},
+	{"net_send_rate", "network", func(s *Stats) string { return csvFloat(s.Network.SendRate) }},
+	{"net_recv_rate", "network", func(s *Stats) string { return csvFloat(s.Network.RecvRate) }},
+	{"load1", "load", func(s *Stats) string { return csvFloat(s.Load.Load1) }},
+	{"load5", "load", func(s *Stats) string { return csvFloat(s.Load.Load5) }},
+	{"load15", "load", func(s *Stats) string { return csvFloat(s.Load.Load15) }},
+	{"uptime_seconds", "host", func(s *Stats) string { return csvUint(s.UptimeSeconds) }},
+	{"process_count", "processes", func(s *Stats) string { return strconv.Itoa(s.ProcessCount) }},
+	{"thread_count", "processes", func(s *Stats) string { return strconv.Itoa(s.ThreadCount) }},
+}
+
+// csvStatsHandler serves the current scalar stats as a header row and a
+// value row, as a download.
+func csvStatsHandler(cache *statsCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := cache.get(r.Context(), nil)
+		if err != nil {
+			writeStatsError(w, err)
+			return
+		}
+		header := make([]string, len(csvColumns))
+		values := make([]string, len(csvColumns))
+		for i, col := range csvColumns {
+			header[i] = col.name
+			if col.collector == "" || cache.cfg.Sections.collected(stats, col.collector) {
+				values[i] = col.value(stats)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="stats.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.Write(values)
+		cw.Flush()
+	}
+}
@@ -0,0 +1,186 @@
+// The gRPC interface served on GRPC_PORT. Stats mirrors the JSON from
+// /api/stats under the same field names, except where JSON uses names proto
+// can't (the load averages' "1min" and so on). The process lists that
+// /api/stats only returns on request are left out.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: dashboard.proto
+
+package dashboardpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Dashboard_GetStats_FullMethodName    = "/dashboard.v1.Dashboard/GetStats"
+	Dashboard_StreamStats_FullMethodName = "/dashboard.v1.Dashboard/StreamStats"
+)
+
+// DashboardClient is the client API for Dashboard service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DashboardClient interface {
+	// GetStats returns the current stats, like GET /api/stats.
+	GetStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Stats, error)
+	// StreamStats sends every sample, like /api/stream, until the client
+	// cancels or the server shuts down.
+	StreamStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Dashboard_StreamStatsClient, error)
+}
+
+type dashboardClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDashboardClient(cc grpc.ClientConnInterface) DashboardClient {
+	return &dashboardClient{cc}
+}
+
+func (c *dashboardClient) GetStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Stats, error) {
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, Dashboard_GetStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dashboardClient) StreamStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Dashboard_StreamStatsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Dashboard_ServiceDesc.Streams[0], Dashboard_StreamStats_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dashboardStreamStatsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Dashboard_StreamStatsClient interface {
+	Recv() (*Stats, error)
+	grpc.ClientStream
+}
+
+type dashboardStreamStatsClient struct {
+	grpc.ClientStream
+}
+
+func (x *dashboardStreamStatsClient) Recv() (*Stats, error) {
+	m := new(Stats)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DashboardServer is the server API for Dashboard service.
+// All implementations must embed UnimplementedDashboardServer
+// for forward compatibility
+type DashboardServer interface {
+	// GetStats returns the current stats, like GET /api/stats.
+	GetStats(context.Context, *emptypb.Empty) (*Stats, error)
+	// StreamStats sends every sample, like /api/stream, until the client
+	// cancels or the server shuts down.
+	StreamStats(*emptypb.Empty, Dashboard_StreamStatsServer) error
+	mustEmbedUnimplementedDashboardServer()
+}
+
+// UnimplementedDashboardServer must be embedded to have forward compatible implementations.
+type UnimplementedDashboardServer struct {
+}
+
+func (UnimplementedDashboardServer) GetStats(context.Context, *emptypb.Empty) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedDashboardServer) StreamStats(*emptypb.Empty, Dashboard_StreamStatsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamStats not implemented")
+}
+func (UnimplementedDashboardServer) mustEmbedUnimplementedDashboardServer() {}
+
+// UnsafeDashboardServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DashboardServer will
+// result in compilation errors.
+type UnsafeDashboardServer interface {
+	mustEmbedUnimplementedDashboardServer()
+}
+
+func RegisterDashboardServer(s grpc.ServiceRegistrar, srv DashboardServer) {
+	s.RegisterService(&Dashboard_ServiceDesc, srv)
+}
+
+func _Dashboard_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DashboardServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dashboard_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DashboardServer).GetStats(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dashboard_StreamStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(emptypb.Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DashboardServer).StreamStats(m, &dashboardStreamStatsServer{stream})
+}
+
+type Dashboard_StreamStatsServer interface {
+	Send(*Stats) error
+	grpc.ServerStream
+}
+
+type dashboardStreamStatsServer struct {
+	grpc.ServerStream
+}
+
+func (x *dashboardStreamStatsServer) Send(m *Stats) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Dashboard_ServiceDesc is the grpc.ServiceDesc for Dashboard service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Dashboard_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dashboard.v1.Dashboard",
+	HandlerType: (*DashboardServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStats",
+			Handler:    _Dashboard_GetStats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamStats",
+			Handler:       _Dashboard_StreamStats_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "dashboard.proto",
+}
@@ -0,0 +1,4 @@
+// Package dashboardpb holds the generated code for dashboard.proto.
+package dashboardpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative dashboard.proto
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultDiffWindow = 5 * time.Minute
+
+// DiffResponse is the change in each scalar metric from From to To, as
+// To minus From. Metrics whose collector is disabled, or failed in either
+// sample, are omitted.
+type DiffResponse struct {
+	Window        string    `json:"window"` // as requested
+	From          time.Time `json:"from"`   // historical sample compared
+	To            time.Time `json:"to"`     // newest sample
+	CPUPercent    *float64  `json:"cpu_percent,omitempty"`
+	MemoryPercent *float64  `json:"memory_percent,omitempty"`
+	DiskPercent   *float64  `json:"disk_percent,omitempty"`
+	Load1         *float64  `json:"load1,omitempty"`
+	Load5         *float64  `json:"load5,omitempty"`
+	Load15        *float64  `json:"load15,omitempty"`
+	SendRate      *float64  `json:"send_rate,omitempty"`
+	RecvRate      *float64  `json:"recv_rate,omitempty"`
+}
+
+// diffHandler compares the newest sample with the one closest to ?window=
+// (default 5m) before now. Samples are taken every interval, so history
+// reaching to within one interval of the window's start counts as spanning
+// it; anything shorter is an error rather than a misleadingly short diff.
+func diffHandler(src historySource, sections sectionSet, interval time.Duration, places int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		window := defaultDiffWindow
+		if v := r.URL.Query().Get("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, "window must be a positive duration, e.g. 5m")
+				return
+			}
+			window = d
+		}
+
+		target := time.Now().Add(-window)
+		samples, err := src.samples(target.Add(-interval), time.Time{}, 0)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(samples) < 2 || samples[0].Timestamp.After(target.Add(interval)) {
+			span := time.Duration(0)
+			if len(samples) > 0 {
+				span = time.Since(samples[0].Timestamp).Round(time.Second)
+			}
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("history does not span %s (oldest sample is %s old)", window, span))
+			return
+		}
+
+		from := samples[0]
+		for _, s := range samples[1:] {
+			if s.Timestamp.Sub(target).Abs() < from.Timestamp.Sub(target).Abs() {
+				from = s
+			}
+		}
+		to := samples[len(samples)-1]
+		resp := DiffResponse{Window: window.String(), From: from.Timestamp, To: to.Timestamp}
+		both := func(collector string) bool {
+			return sections.collected(from, collector) && sections.collected(to, collector)
+		}
+		change := func(newer, older float64, places int) *float64 {
+			d := round(newer-older, places)
+			return &d
+		}
+		if both("cpu") {
+			resp.CPUPercent = change(to.CPUPercent, from.CPUPercent, places)
+		}
+		if both("memory") {
+			resp.MemoryPercent = change(to.Memory.Percent, from.Memory.Percent, places)
+		}
+		if both("disk") {
+			resp.DiskPercent = change(to.Disk.Percent, from.Disk.Percent, places)
+		}
+		if both("load") {
+			resp.Load1 = change(to.Load.Load1, from.Load.Load1, places+1)
+			resp.Load5 = change(to.Load.Load5, from.Load.Load5, places+1)
+			resp.Load15 = change(to.Load.Load15, from.Load.Load15, places+1)
+		}
+		if both("network") {
+			resp.SendRate = change(to.Network.SendRate, from.Network.SendRate, places)
+			resp.RecvRate = change(to.Network.RecvRate, from.Network.RecvRate, places)
+		}
+		writeJSON(w, r, http.StatusOK, resp)
+	}
+}
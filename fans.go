@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const hwmonDir = "/sys/class/hwmon"
+
+type FanStats struct {
+	Label string `json:"label"`
+	RPM   uint64 `json:"rpm"`
+}
+
+// collectFans reads every fan*_input under /sys/class/hwmon. Fans without
+// a fan*_label are named after their chip, e.g. "nct6775/fan2". Hosts that
+// expose no fan sensors report an empty list.
+func collectFans(ctx context.Context, cfg *Config, s *Stats) error {
+	s.Fans = []FanStats{}
+	inputs, _ := filepath.Glob(filepath.Join(hwmonDir, "hwmon*", "fan*_input"))
+	sort.Strings(inputs)
+	for _, input := range inputs {
+		dir := filepath.Dir(input)
+		fan := strings.TrimSuffix(filepath.Base(input), "_input")
+		rpm, err := readSysfsUint(dir, fan+"_input")
+		if err != nil {
+			continue
+		}
+		label := readSysfs(dir, fan+"_label")
+		if label == "" {
+			chip := readSysfs(dir, "name")
+			if chip == "" {
+				chip = filepath.Base(dir)
+			}
+			label = chip + "/" + fan
+		}
+		s.Fans = append(s.Fans, FanStats{Label: label, RPM: rpm})
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type GPUStats struct {
+	Index              int     `json:"index"`
+	Name               string  `json:"name"`
+	UtilizationPercent float64 `json:"utilization_percent"`
+	MemoryUsed         uint64  `json:"memory_used"`
+	MemoryTotal        uint64  `json:"memory_total"`
+	Temperature        float64 `json:"temperature"`
+}
+
+const nvidiaSMIQuery = "index,name,utilization.gpu,memory.used,memory.total,temperature.gpu"
+
+// collectGPUs queries NVIDIA GPUs through nvidia-smi when cfg.GPUEnabled is
+// set. Hosts without nvidia-smi get an empty list rather than an error.
+func collectGPUs(ctx context.Context, cfg *Config, s *Stats) error {
+	if !cfg.GPUEnabled {
+		return nil
+	}
+	s.GPUs = []GPUStats{}
+	out, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu="+nvidiaSMIQuery, "--format=csv,noheader,nounits").Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("nvidia-smi: %w", err)
+	}
+
+	r := csv.NewReader(strings.NewReader(string(out)))
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return fmt.Errorf("parsing nvidia-smi output: %w", err)
+	}
+	for _, rec := range records {
+		if len(rec) != 6 {
+			continue
+		}
+		index, _ := strconv.Atoi(rec[0])
+		s.GPUs = append(s.GPUs, GPUStats{
+			Index:              index,
+			Name:               rec[1],
+			UtilizationPercent: parseSMIFloat(rec[2]),
+			MemoryUsed:         uint64(parseSMIFloat(rec[3])) << 20, // MiB
+			MemoryTotal:        uint64(parseSMIFloat(rec[4])) << 20,
+			Temperature:        parseSMIFloat(rec[5]),
+		})
+	}
+	return nil
+}
+
+// parseSMIFloat parses a numeric nvidia-smi field, treating values such as
+// "[N/A]" or "[Not Supported]" as 0.
+func parseSMIFloat(v string) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
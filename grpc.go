@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"server-dashboard/dashboardpb"
+)
+
+// statsService implements the Dashboard service in dashboardpb/dashboard.proto.
+type statsService struct {
+	dashboardpb.UnimplementedDashboardServer
+	cache   *statsCache
+	smp     *sampler
+	streams *streamTracker
+}
+
+// newGRPCServer serves svc, over TLS when certFile and keyFile are set. With
+// user set, calls need the same basic auth credentials as the HTTP API, sent
+// as "authorization" metadata.
+func newGRPCServer(svc *statsService, user, pass, certFile, keyFile string) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if certFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if user != "" {
+		check := func(ctx context.Context) error {
+			md, _ := metadata.FromIncomingContext(ctx)
+			r := &http.Request{Header: http.Header{"Authorization": md.Get("authorization")}}
+			u, p, ok := r.BasicAuth()
+			userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user))
+			passOK := subtle.ConstantTimeCompare([]byte(p), []byte(pass))
+			if !ok || userOK&passOK != 1 {
+				return status.Error(codes.Unauthenticated, "unauthorized")
+			}
+			return nil
+		}
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				if err := check(ctx); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := check(ss.Context()); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}),
+		)
+	}
+	srv := grpc.NewServer(opts...)
+	dashboardpb.RegisterDashboardServer(srv, svc)
+	return srv, nil
+}
+
+func (s *statsService) GetStats(ctx context.Context, _ *emptypb.Empty) (*dashboardpb.Stats, error) {
+	stats, err := s.cache.get(ctx, nil)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, status.Error(codes.DeadlineExceeded, "stats collection timed out")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return statsProto(stats, s.cache.cfg.Sections), nil
+}
+
+func (s *statsService) StreamStats(_ *emptypb.Empty, stream dashboardpb.Dashboard_StreamStatsServer) error {
+	defer s.streams.start()()
+	updates, unsubscribe := s.smp.subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.streams.closing:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case stats := <-updates:
+			if err := stream.Send(statsProto(stats, s.cache.cfg.Sections)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// statsProto converts stats to the message in dashboard.proto, leaving out
+// the sections outside sections. New Stats fields need adding both there
+// and here.
+func statsProto(s *Stats, sections sectionSet) *dashboardpb.Stats {
+	m := &dashboardpb.Stats{
+		Hostname:       s.Hostname,
+		CpuPercent:     s.CPUPercent,
+		PerCorePercent: s.PerCorePercent,
+		CpuLimit:       s.CPULimit,
+		CpuInfo: &dashboardpb.CPUInfo{
+			PhysicalCores: int32(s.CPUInfo.PhysicalCores),
+			LogicalCores:  int32(s.CPUInfo.LogicalCores),
+			ModelName:     s.CPUInfo.ModelName,
+			Mhz:           s.CPUInfo.Mhz,
+		},
+		Memory: &dashboardpb.Memory{
+			Total:     s.Memory.Total,
+			Used:      s.Memory.Used,
+			Percent:   s.Memory.Percent,
+			Available: s.Memory.Available,
+			Free:      s.Memory.Free,
+			Cached:    s.Memory.Cached,
+			Buffers:   s.Memory.Buffers,
+			Container: s.Memory.Container,
+		},
+		Swap: &dashboardpb.Swap{
+			Total:   s.Swap.Total,
+			Used:    s.Swap.Used,
+			Free:    s.Swap.Free,
+			Percent: s.Swap.Percent,
+		},
+		MemoryPressure: s.MemoryPressure,
+		Disk:           diskProto(s.Disk),
+		DiskLow:        s.DiskLow,
+		DiskIo: &dashboardpb.DiskIO{
+			ReadBytesRate:  s.DiskIO.ReadBytesRate,
+			WriteBytesRate: s.DiskIO.WriteBytesRate,
+			ReadOps:        s.DiskIO.ReadOps,
+			WriteOps:       s.DiskIO.WriteOps,
+		},
+		Network: &dashboardpb.Network{
+			BytesSent: s.Network.BytesSent,
+			BytesRecv: s.Network.BytesRecv,
+			SendRate:  s.Network.SendRate,
+			RecvRate:  s.Network.RecvRate,
+		},
+		Load: &dashboardpb.Load{
+			Load1:         s.Load.Load1,
+			Load5:         s.Load.Load5,
+			Load15:        s.Load.Load15,
+			Load1PerCore:  s.Load.Load1PerCore,
+			Load5PerCore:  s.Load.Load5PerCore,
+			Load15PerCore: s.Load.Load15PerCore,
+			Load1Trend:    s.Load.Load1Trend,
+			Load1Slope:    s.Load.Load1Slope,
+		},
+		MaxTemperature: s.MaxTemperature,
+		ThermalWarning: s.ThermalWarning,
+		Battery: &dashboardpb.Battery{
+			Present:              s.Battery.Present,
+			Percent:              s.Battery.Percent,
+			Charging:             s.Battery.Charging,
+			TimeRemainingSeconds: s.Battery.TimeRemaining,
+		},
+		Uptime:        s.Uptime,
+		UptimeSeconds: s.UptimeSeconds,
+		System: &dashboardpb.SystemInfo{
+			Os:              s.System.OS,
+			Platform:        s.System.Platform,
+			PlatformVersion: s.System.PlatformVersion,
+			KernelVersion:   s.System.KernelVersion,
+			KernelArch:      s.System.KernelArch,
+			BootTime:        s.System.BootTime,
+		},
+		UserCount:            int32(s.UserCount),
+		ProcessCount:         int32(s.ProcessCount),
+		ThreadCount:          int32(s.ThreadCount),
+		OpenFiles:            s.OpenFiles,
+		MaxFiles:             s.MaxFiles,
+		OpenFilesPercent:     s.OpenFilesPercent,
+		Anomalies:            s.Anomalies,
+		Timestamp:            timestamppb.New(s.Timestamp),
+		CollectionDurationMs: s.CollectionDurationMs,
+		Errors:               s.Errors,
+	}
+	if t := s.CPUTimes; t != nil {
+		m.CpuTimes = &dashboardpb.CPUTimes{User: t.User, System: t.System, Idle: t.Idle, Iowait: t.Iowait, Steal: t.Steal}
+	}
+	if psi := s.MemoryPSI; psi != nil {
+		m.MemoryPsi = &dashboardpb.PSI{SomeAvg10: psi.SomeAvg10, FullAvg10: psi.FullAvg10}
+	}
+	for _, d := range s.Disks {
+		m.Disks = append(m.Disks, diskProto(d))
+	}
+	for _, d := range s.DiskIO.Devices {
+		m.DiskIo.Devices = append(m.DiskIo.Devices, &dashboardpb.DeviceIO{
+			Name:           d.Name,
+			Mountpoint:     d.Mountpoint,
+			ReadBytesRate:  d.ReadBytesRate,
+			WriteBytesRate: d.WriteBytesRate,
+			ReadOps:        d.ReadOps,
+			WriteOps:       d.WriteOps,
+		})
+	}
+	for _, i := range s.Interfaces {
+		m.Interfaces = append(m.Interfaces, &dashboardpb.Interface{
+			Name:        i.Name,
+			Loopback:    i.Loopback,
+			BytesSent:   i.BytesSent,
+			BytesRecv:   i.BytesRecv,
+			PacketsSent: i.PacketsSent,
+			PacketsRecv: i.PacketsRecv,
+			Errin:       i.Errin,
+			Errout:      i.Errout,
+			Dropin:      i.Dropin,
+			Dropout:     i.Dropout,
+			SpeedMbps:   i.Speed,
+			ErrinRate:   i.ErrinRate,
+			ErroutRate:  i.ErroutRate,
+			DropinRate:  i.DropinRate,
+			DropoutRate: i.DropoutRate,
+		})
+	}
+	for _, t := range s.Temperatures {
+		m.Temperatures = append(m.Temperatures, &dashboardpb.Sensor{SensorKey: t.SensorKey, Temperature: t.Temperature, High: t.High, Critical: t.Critical})
+	}
+	for _, f := range s.Fans {
+		m.Fans = append(m.Fans, &dashboardpb.Fan{Label: f.Label, Rpm: f.RPM})
+	}
+	for _, g := range s.GPUs {
+		m.Gpus = append(m.Gpus, &dashboardpb.GPU{
+			Index:              int32(g.Index),
+			Name:               g.Name,
+			UtilizationPercent: g.UtilizationPercent,
+			MemoryUsed:         g.MemoryUsed,
+			MemoryTotal:        g.MemoryTotal,
+			Temperature:        g.Temperature,
+		})
+	}
+	for _, u := range s.Users {
+		m.Users = append(m.Users, &dashboardpb.User{User: u.User, Terminal: u.Terminal, Host: u.Host, Started: u.Started})
+	}
+	if p := s.WatchedProcess; p != nil {
+		m.WatchedProcess = &dashboardpb.Process{
+			Pid:           p.PID,
+			Name:          p.Name,
+			Running:       p.Running,
+			Count:         int32(p.Count),
+			CpuPercent:    p.CPUPercent,
+			MemoryPercent: p.MemoryPercent,
+			Rss:           p.RSS,
+			NumThreads:    p.NumThreads,
+		}
+	}
+	// Scalars of disabled sections are already zero, which proto3 doesn't
+	// send; clear their messages too.
+	for _, c := range []struct {
+		name  string
+		clear func()
+	}{
+		{"cpu", func() { m.CpuInfo = nil }},
+		{"memory", func() { m.Memory = nil }},
+		{"swap", func() { m.Swap = nil }},
+		{"disk", func() { m.Disk = nil }},
+		{"diskio", func() { m.DiskIo = nil }},
+		{"network", func() { m.Network = nil }},
+		{"load", func() { m.Load = nil }},
+		{"battery", func() { m.Battery = nil }},
+		{"host", func() { m.System = nil }},
+	} {
+		if !sections.has(c.name) {
+			c.clear()
+		}
+	}
+	return m
+}
+
+func diskProto(d DiskStats) *dashboardpb.Disk {
+	return &dashboardpb.Disk{
+		Mountpoint:        d.Mountpoint,
+		Fstype:            d.Fstype,
+		Total:             d.Total,
+		Used:              d.Used,
+		Free:              d.Free,
+		Percent:           d.Percent,
+		InodesTotal:       d.InodesTotal,
+		InodesUsed:        d.InodesUsed,
+		InodesUsedPercent: d.InodesUsedPercent,
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// historySource is where /api/history reads samples from: the in-memory
+// ring buffer by default, or the SQLite store when DB_PATH is set.
+type historySource interface {
+	// samples returns samples taken within [from, to], oldest first, capped
+	// to the newest limit (0 means no cap). Zero times leave a side open.
+	samples(from, to time.Time, limit int) ([]*Stats, error)
+}
+
+// history is a fixed-size ring buffer of the most recent samples.
+type history struct {
+	mu   sync.RWMutex
+	buf  []*Stats
+	next int
+	full bool
+}
+
+func newHistory(size int) *history {
+	return &history{buf: make([]*Stats, size)}
+}
+
+// add stores s, overwriting the oldest sample once the buffer is full.
+func (h *history) add(s *Stats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf[h.next] = s
+	h.next = (h.next + 1) % len(h.buf)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// snapshot returns up to limit of the most recent samples, oldest first.
+// A limit of 0 or less returns everything held.
+func (h *history) snapshot(limit int) []*Stats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	out := make([]*Stats, 0, len(h.buf))
+	if h.full {
+		out = append(out, h.buf[h.next:]...)
+	}
+	out = append(out, h.buf[:h.next]...)
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+func (h *history) samples(from, to time.Time, limit int) ([]*Stats, error) {
+	all := h.snapshot(0)
+	out := make([]*Stats, 0, len(all))
+	for _, s := range all {
+		if (from.IsZero() || !s.Timestamp.Before(from)) && (to.IsZero() || !s.Timestamp.After(to)) {
+			out = append(out, s)
+		}
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out, nil
+}
+
+// historyHandler serves stored samples oldest-to-newest. ?from= and ?to=
+// (RFC3339) restrict the time range and ?limit=N keeps only the newest N.
+// Samples are trimmed to sections, as on /api/stats.
+func historyHandler(src historySource, sections sectionSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit := 0
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+			limit = n
+		}
+		var from, to time.Time
+		for _, p := range []struct {
+			name string
+			dst  *time.Time
+		}{{"from", &from}, {"to", &to}} {
+			if v := query.Get(p.name); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, p.name+" must be an RFC3339 timestamp")
+					return
+				}
+				*p.dst = t
+			}
+		}
+
+		samples, err := src.samples(from, to, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out := make([]any, len(samples))
+		for i, s := range samples {
+			if out[i], err = sections.view(s); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		writeJSON(w, r, http.StatusOK, out)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+)
+
+// unixSocketMode lets the owner and group connect; add users to the group
+// to grant access instead of opening the socket to everyone.
+const unixSocketMode = 0o660
+
+// listen opens the listener the server runs on: the Unix socket at
+// cfg.UnixSocket when set, and the TCP listen address otherwise.
+func listen(cfg *Config) (net.Listener, error) {
+	if cfg.UnixSocket == "" {
+		return net.Listen("tcp", cfg.listenAddr())
+	}
+	if err := removeStaleSocket(cfg.UnixSocket); err != nil {
+		return nil, err
+	}
+	// Go removes the socket file again when the listener is closed, which
+	// srv.Shutdown does.
+	ln, err := net.Listen("unix", cfg.UnixSocket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(cfg.UnixSocket, unixSocketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket deletes a socket left behind by an unclean exit. Other
+// kinds of file at path are left alone so a typo can't delete real data.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+// listenURL describes where the server can be reached, for the startup log.
+func listenURL(ln net.Listener, tls bool) string {
+	scheme := "http"
+	if tls {
+		scheme = "https"
+	}
+	if ln.Addr().Network() == "unix" {
+		return scheme + "+unix://" + ln.Addr().String()
+	}
+	return scheme + "://" + ln.Addr().String()
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// setupLogging installs the default slog logger. "text" keeps slog's
+// default handler, which writes through the standard log package in the
+// familiar "2006/01/02 15:04:05 INFO msg key=value" form; "json" writes one
+// JSON object per line to stderr.
+func setupLogging(format string) {
+	if format == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	}
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
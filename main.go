@@ -1,178 +1,546 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/host"
-	"github.com/shirou/gopsutil/v3/load"
-	"github.com/shirou/gopsutil/v3/mem"
-	"github.com/shirou/gopsutil/v3/net"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
 )
 
 //go:embed static/*
 var staticFiles embed.FS
 
-type Stats struct {
-	Hostname   string        `json:"hostname"`
-	CPUPercent float64       `json:"cpu_percent"`
-	Memory     MemoryStats   `json:"memory"`
-	Disk       DiskStats     `json:"disk"`
-	Network    NetworkStats  `json:"network"`
-	Load       LoadStats     `json:"load"`
-	Uptime     string        `json:"uptime"`
-	Timestamp  time.Time     `json:"timestamp"`
+// prettyJSON is the default for wantPretty, from PRETTY_JSON.
+var prettyJSON bool
+
+// wantPretty reports whether the response to r should be indented:
+// ?pretty=1 or ?pretty=0 if given, otherwise PRETTY_JSON.
+func wantPretty(r *http.Request) bool {
+	query := r.URL.Query()
+	if !query.Has("pretty") {
+		return prettyJSON
+	}
+	pretty, err := strconv.ParseBool(query.Get("pretty"))
+	return err == nil && pretty
 }
 
-type MemoryStats struct {
-	Total   uint64  `json:"total"`
-	Used    uint64  `json:"used"`
-	Percent float64 `json:"percent"`
+// writeJSON sends v as a JSON body with the given status code, indented
+// if r asks for it.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	if wantPretty(r) {
+		enc.SetIndent("", "  ")
+	}
+	enc.Encode(v)
 }
 
-type DiskStats struct {
-	Total   uint64  `json:"total"`
-	Used    uint64  `json:"used"`
-	Percent float64 `json:"percent"`
+// writeError sends a JSON error body with the given status code. Errors
+// are short, so they are never indented.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
-type NetworkStats struct {
-	BytesSent uint64 `json:"bytes_sent"`
-	BytesRecv uint64 `json:"bytes_recv"`
+// writeStatsError reports a failed stats collection, distinguishing a
+// collection that ran out of time.
+func writeStatsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeError(w, http.StatusGatewayTimeout, "stats collection timed out")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
 }
 
-type LoadStats struct {
-	Load1  float64 `json:"1min"`
-	Load5  float64 `json:"5min"`
-	Load15 float64 `json:"15min"`
+// healthHandler is a cheap liveness probe; it deliberately avoids any
+// gopsutil calls so probes don't trigger CPU sampling.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-func formatUptime(seconds uint64) string {
-	days := seconds / 86400
-	hours := (seconds % 86400) / 3600
-	minutes := (seconds % 3600) / 60
+// UIConfig holds hints for the embedded dashboard.
+type UIConfig struct {
+	PollIntervalMs int64 `json:"poll_interval_ms"`
+}
 
-	if days > 0 {
-		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
-	} else if hours > 0 {
-		return fmt.Sprintf("%dh %dm", hours, minutes)
+// uiConfigHandler tells the frontend how often to poll: new samples only
+// appear once per SAMPLE_INTERVAL, so polling faster just re-fetches them.
+func uiConfigHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, UIConfig{PollIntervalMs: cfg.SampleInterval.Milliseconds()})
 	}
-	return fmt.Sprintf("%dm", minutes)
 }
 
-func getStats() (*Stats, error) {
-	// Hostname
-	hostname, err := os.Hostname()
-	if err != nil {
-		hostname = "unknown"
-	}
+// statsHandler serves the current stats. Passing ?processes=N (N defaults
+// to 10 when empty) also lists the top N processes, sorted by CPU or, with
+// ?sort=memory, by memory. ?procstates=1 adds process counts by state; it
+// is also included whenever ?processes is.
+//
+// ?nowait=1 skips the blocking CPU sample and reports usage since the
+// previous reading instead; the first such request after startup may
+// report 0 CPU. It bypasses the cache, whose entries may have to wait for
+// a full sample.
+//
+// ?fields=cpu,memory restricts the response (and the collection) to the
+// named sections, which are the collector names in stats.go. Unknown names
+// are ignored rather than rejected, so clients keep working against older
+// servers; the hostname, timestamp, collection duration and errors are
+// always included.
+//
+// POST takes the options as a JSON body instead; see postStats.
+func statsHandler(cache *statsCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			postStats(w, r, cache.cfg)
+			return
+		}
+		query := r.URL.Query()
+		topN := 0
+		if query.Has("processes") {
+			topN = defaultTopProcesses
+			if v := query.Get("processes"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					writeError(w, http.StatusBadRequest, "processes must be a positive integer")
+					return
+				}
+				topN = n
+			}
+		}
 
-	// CPU
-	cpuPercent, err := cpu.Percent(time.Second, false)
-	if err != nil {
-		return nil, fmt.Errorf("cpu: %w", err)
-	}
-	cpuPct := 0.0
-	if len(cpuPercent) > 0 {
-		cpuPct = cpuPercent[0]
+		sections := parseSections(query.Get("fields")).within(cache.cfg.Sections)
+		var stats *Stats
+		var err error
+		if query.Get("nowait") == "1" {
+			stats, err = statsNoWait(r.Context(), cache.cfg, sections)
+		} else {
+			stats, err = cache.get(r.Context(), sections)
+		}
+		if err != nil {
+			writeStatsError(w, err)
+			return
+		}
+
+		wantStates := topN > 0 || query.Get("procstates") == "1"
+		if wantStates {
+			// Cached stats are shared, so attach the lists to a copy.
+			withProcs := *stats
+			if topN > 0 {
+				top, err := topProcesses(r.Context(), topN, query.Get("sort") == "memory", cache.cfg.Precision)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, "processes: "+err.Error())
+					return
+				}
+				withProcs.TopProcesses = top
+			}
+			states, err := processStates(r.Context())
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "procstates: "+err.Error())
+				return
+			}
+			withProcs.ProcessStates = states
+			stats = &withProcs
+		}
+
+		writeStats(w, r, stats, sections)
 	}
+}
 
-	// Memory
-	memInfo, err := mem.VirtualMemory()
-	if err != nil {
-		return nil, fmt.Errorf("memory: %w", err)
+// statsNoWait collects stats with a non-blocking CPU reading, for
+// ?nowait=1.
+func statsNoWait(ctx context.Context, cfg *Config, sections sectionSet) (*Stats, error) {
+	opts := *cfg
+	opts.CPUSampleInterval = 0
+	ctx, cancel := context.WithTimeout(ctx, cfg.StatsTimeout)
+	defer cancel()
+	return getStats(ctx, &opts, sections)
+}
+
+// CompactStats is the flat payload of /api/stats/compact, for clients that
+// can barely parse JSON. Keep this list in sync with those clients:
+//
+//	c  CPU percent
+//	m  memory used percent
+//	d  disk used percent (DISK_PATH)
+//	l  1-minute load average
+//
+// A key is left out when its collector is disabled or failed.
+type CompactStats struct {
+	CPU    *float64 `json:"c,omitempty"`
+	Memory *float64 `json:"m,omitempty"`
+	Disk   *float64 `json:"d,omitempty"`
+	Load1  *float64 `json:"l,omitempty"`
+}
+
+// compactSections are the only collectors /api/stats/compact needs.
+var compactSections = sectionSet{"cpu": true, "memory": true, "disk": true, "load": true}
+
+func compactStatsHandler(cache *statsCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sections := compactSections.within(cache.cfg.Sections)
+		stats, err := cache.get(r.Context(), sections)
+		if err != nil {
+			writeStatsError(w, err)
+			return
+		}
+		var c CompactStats
+		if sections.collected(stats, "cpu") {
+			c.CPU = &stats.CPUPercent
+		}
+		if sections.collected(stats, "memory") {
+			c.Memory = &stats.Memory.Percent
+		}
+		if sections.collected(stats, "disk") {
+			c.Disk = &stats.Disk.Percent
+		}
+		if sections.collected(stats, "load") {
+			c.Load1 = &stats.Load.Load1
+		}
+		writeJSON(w, r, http.StatusOK, c)
 	}
+}
 
-	// Disk
-	diskInfo, err := disk.Usage("/")
-	if err != nil {
-		return nil, fmt.Errorf("disk: %w", err)
+// statsRequest is the body of POST /api/stats. Omitted fields use the
+// server defaults.
+type statsRequest struct {
+	CPUInterval *string  `json:"cpu_interval"` // time.ParseDuration format; "0s" is non-blocking
+	Sections    []string `json:"sections"`     // same names as ?fields=
+}
+
+// postStats collects stats with a caller-chosen CPU sample window and set
+// of sections. The result depends on the options, so it bypasses the cache.
+func postStats(w http.ResponseWriter, r *http.Request, cfg *Config) {
+	var req statsRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
 	}
 
-	// Network
-	netInfo, err := net.IOCounters(false)
-	if err != nil {
-		return nil, fmt.Errorf("network: %w", err)
+	opts := *cfg
+	if req.CPUInterval != nil {
+		d, err := time.ParseDuration(*req.CPUInterval)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cpu_interval: "+err.Error())
+			return
+		}
+		// The sample has to finish within the collection timeout.
+		if d < 0 || d >= cfg.StatsTimeout {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("cpu_interval must be at least 0 and less than %s", cfg.StatsTimeout))
+			return
+		}
+		opts.CPUSampleInterval = d
+		opts.CPUPrewarmInterval = 0 // the caller wants this window, not the background one
 	}
-	var bytesSent, bytesRecv uint64
-	if len(netInfo) > 0 {
-		bytesSent = netInfo[0].BytesSent
-		bytesRecv = netInfo[0].BytesRecv
+	var sections sectionSet
+	if len(req.Sections) > 0 {
+		sections = parseSections(strings.Join(req.Sections, ","))
 	}
+	sections = sections.within(cfg.Sections)
 
-	// Load
-	loadInfo, err := load.Avg()
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.StatsTimeout)
+	defer cancel()
+	stats, err := getStats(ctx, &opts, sections)
 	if err != nil {
-		return nil, fmt.Errorf("load: %w", err)
+		writeStatsError(w, err)
+		return
 	}
+	writeStats(w, r, stats, sections)
+}
 
-	// Uptime
-	hostInfo, err := host.Info()
+// writeStats writes stats restricted to sections (nil for all of them).
+// GET responses carry an ETag of the body: stats served from the cache are
+// identical until the TTL expires, so re-polling clients get a 304 instead.
+func writeStats(w http.ResponseWriter, r *http.Request, stats *Stats, sections sectionSet) {
+	v, err := sections.view(stats)
 	if err != nil {
-		return nil, fmt.Errorf("host: %w", err)
-	}
-
-	stats := &Stats{
-		Hostname:   hostname,
-		CPUPercent: float64(int(cpuPct*10)) / 10, // Round to 1 decimal
-		Memory: MemoryStats{
-			Total:   memInfo.Total,
-			Used:    memInfo.Used,
-			Percent: float64(int(memInfo.UsedPercent*10)) / 10,
-		},
-		Disk: DiskStats{
-			Total:   diskInfo.Total,
-			Used:    diskInfo.Used,
-			Percent: float64(int(diskInfo.UsedPercent*10)) / 10,
-		},
-		Network: NetworkStats{
-			BytesSent: bytesSent,
-			BytesRecv: bytesRecv,
-		},
-		Load: LoadStats{
-			Load1:  float64(int(loadInfo.Load1*100)) / 100,
-			Load5:  float64(int(loadInfo.Load5*100)) / 100,
-			Load15: float64(int(loadInfo.Load15*100)) / 100,
-		},
-		Uptime:    formatUptime(hostInfo.Uptime),
-		Timestamp: time.Now(),
-	}
-
-	return stats, nil
-}
-
-func statsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if r.Method == http.MethodPost {
+		writeJSON(w, r, http.StatusOK, v)
+		return
+	}
 
-	stats, err := getStats()
+	var body []byte
+	if wantPretty(r) {
+		body, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		body, err = json.Marshal(v)
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	etag := contentETag(body)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
 
-	json.NewEncoder(w).Encode(stats)
+// contentETag returns a strong ETag for body.
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using the weak comparison RFC 9110 prescribes for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
 }
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
+	configPath := flag.String("config", "", "path to a JSON config file (environment variables take precedence)")
+	hub := flag.Bool("hub", false, "poll the instances listed in PEERS or PEERS_FILE and serve /api/fleet instead of sampling locally")
+	check := flag.Bool("check", false, "collect stats once, print them and exit non-zero if any collector fails")
+	flag.Parse()
+
+	// Set up logging first so warnings about the rest of the config use the
+	// chosen format too; loadConfig still rejects unknown formats.
+	setupLogging(os.Getenv("LOG_FORMAT"))
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	if *hub && len(cfg.Peers) == 0 && cfg.PeersFile == "" {
+		fatal("Invalid configuration", "err", "--hub requires PEERS or PEERS_FILE")
+	}
+	cpuSamples = newSampleGate(cfg.MaxConcurrentSamples)
+	trustProxy = cfg.TrustProxy
+	prettyJSON = cfg.PrettyJSON
+	if containerAware(cfg.ContainerAware) {
+		cgroupLimits = openCgroup()
+	}
+	if *check {
+		os.Exit(runCheck(cfg))
 	}
 
-	// Serve static files
-	http.Handle("/", http.FileServer(http.FS(staticFiles)))
+	if _, err := os.Stat(cfg.DiskPath); err != nil {
+		slog.Warn("DISK_PATH is not usable", "path", cfg.DiskPath, "err", err)
+	}
+	if err := probeProc(context.Background()); err != nil {
+		slog.Warn("/proc is not readable, most stats will be missing; set HOST_PROC if it is mounted elsewhere", "err", err)
+		procMissing = true
+	}
+
+	ctx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	mux := http.NewServeMux()
+	streams := newStreamTracker()
+	var grpcSrv *grpc.Server
+
+	// Serve static files, unless this is an API-only deployment; then
+	// anything unrouted gets a JSON 404 instead.
+	if cfg.ServeStatic {
+		mux.Handle("/", staticHandler(cfg.Theme, cfg.DashboardTitle, cfg.StaticMaxAge))
+		// The dashboard used to be served from /static/; keep old bookmarks working.
+		mux.Handle("/static/", http.RedirectHandler("/", http.StatusMovedPermanently))
+	} else {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusNotFound, "not found")
+		})
+	}
+
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/api/version", versionHandler)
+	mux.HandleFunc("/api/config", uiConfigHandler(cfg))
+
+	if *hub {
+		var filePeers []string
+		if cfg.PeersFile != "" {
+			if filePeers, err = readPeersFile(cfg.PeersFile); err != nil {
+				fatal("Reading PEERS_FILE failed", "err", err)
+			}
+		}
+		slog.Info("Hub mode", "peers", len(cfg.Peers)+len(filePeers), "interval", cfg.SampleInterval.String())
+		f := newFleet(cfg, filePeers)
+		go f.run(ctx)
+		if cfg.PeersFile != "" {
+			go f.watchPeersFile(ctx, cfg.PeersFile)
+		}
+		mux.HandleFunc("/api/fleet", fleetHandler(f))
+	} else {
+		hist := newHistory(cfg.HistorySize)
+		recentHistory = hist
+		smp := newSampler(cfg)
+		smp.addSink(hist.add)
+		if a := newAlerter(cfg); a != nil {
+			go a.run(ctx)
+			smp.addSink(a.check)
+		}
+		if cfg.PushgatewayURL != "" {
+			smp.addSink(newPusher(cfg.PushgatewayURL, cfg.MetricsPrefix, cfg.Sections).push)
+		}
+		if cfg.MQTTBroker != "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				hostname = "unknown"
+			}
+			pub := newMQTTPublisher(cfg.MQTTBroker, cfg.MQTTTopic, hostname, cfg.Sections)
+			defer pub.close()
+			smp.addSink(pub.publish)
+		}
+		var historySrc historySource = hist
+		if cfg.DBPath != "" {
+			st, err := openStore(cfg.DBPath, cfg.HistorySize)
+			if err != nil {
+				fatal("Opening DB_PATH failed", "path", cfg.DBPath, "err", err)
+			}
+			defer st.Close()
+			smp.addSink(st.insert)
+			historySrc = st
+		}
+		go smp.run(ctx)
+		if cfg.CPUPrewarmInterval > 0 {
+			go cpuPrewarm.run(ctx, cfg.CPUPrewarmInterval)
+		}
+
+		cache := newStatsCache(cfg)
 
-	// API endpoint
-	http.HandleFunc("/api/stats", statsHandler)
+		// API endpoints
+		mux.HandleFunc("/api/stats", statsHandler(cache))
+		mux.HandleFunc("/api/stats/compact", compactStatsHandler(cache))
+		mux.HandleFunc("/api/stats.csv", csvStatsHandler(cache))
+		mux.HandleFunc("/metrics", metricsHandler(cache))
+		mux.HandleFunc("/ws", wsHandler(smp, streams))
+		mux.HandleFunc("/api/stream", sseHandler(smp, streams))
+		mux.HandleFunc("/api/history", historyHandler(historySrc, cfg.Sections))
+		mux.HandleFunc("/api/trends", trendsHandler(historySrc, cfg.DiskPath, cfg.Sections))
+		mux.HandleFunc("/api/summary", summaryHandler(historySrc, cfg.Sections, cfg.Precision))
+		mux.HandleFunc("/api/diff", diffHandler(historySrc, cfg.Sections, cfg.SampleInterval, cfg.Precision))
+		mux.HandleFunc("/api/connections", connectionsHandler)
 
-	log.Printf("Server dashboard running on http://0.0.0.0:%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+		if cfg.GRPCPort != "" {
+			grpcLn, err := net.Listen("tcp", net.JoinHostPort(cfg.BindAddr, cfg.GRPCPort))
+			if err != nil {
+				fatal("gRPC server failed", "err", err)
+			}
+			svc := &statsService{cache: cache, smp: smp, streams: streams}
+			grpcSrv, err = newGRPCServer(svc, cfg.AuthUser, cfg.AuthPass, cfg.TLSCertFile, cfg.TLSKeyFile)
+			if err != nil {
+				fatal("gRPC server failed", "err", err)
+			}
+			go func() {
+				slog.Info("gRPC server running", "addr", grpcLn.Addr().String())
+				if err := grpcSrv.Serve(grpcLn); err != nil {
+					slog.Error("gRPC server failed", "err", err)
+				}
+			}()
+		}
+	}
+
+	handler := recoverPanics(mux)
+	if cfg.AuthUser != "" {
+		handler = basicAuth(handler, cfg.AuthUser, cfg.AuthPass)
+	}
+	if cfg.RateLimit > 0 {
+		limiter := newRateLimiter(cfg.RateLimit)
+		go limiter.run(ctx)
+		handler = rateLimit(handler, limiter)
+	}
+	handler = cors(handler, cfg.AllowedOrigin)
+	handler = gzipHandler(handler)
+	if cfg.LogRequests {
+		handler = logRequests(handler)
+	}
+	if cfg.H2C {
+		// Cleartext HTTP/2 for load balancers that speak it; HTTP/1.1
+		// clients are served as before.
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	conns := &connCounter{}
+	srv := &http.Server{
+		Handler:   handler,
+		ErrorLog:  slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+		ConnState: conns.track,
+	}
+	srv.RegisterOnShutdown(streams.close)
+
+	ln, err := listen(cfg)
+	if err != nil {
+		fatal("Server failed", "err", err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server dashboard running", "url", listenURL(ln, cfg.TLSCertFile != ""))
+		if cfg.TLSCertFile != "" {
+			serveErr <- srv.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+			return
+		}
+		serveErr <- srv.Serve(ln)
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErr:
+		fatal("Server failed", "err", err)
+	case sig := <-stop:
+		slog.Info("Shutting down", "signal", sig.String(), "connections", conns.active(), "streams", streams.active())
+	}
+
+	stopBackground()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	start := time.Now()
+	err = srv.Shutdown(shutdownCtx)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.Warn("Drain timed out, closing remaining connections", "timeout", cfg.ShutdownTimeout, "connections", conns.active())
+		srv.Close()
+	case err != nil:
+		slog.Error("Shutdown failed", "err", err)
+	default:
+		slog.Info("Drain completed", "duration", time.Since(start).Round(time.Millisecond))
+	}
+	if grpcSrv != nil {
+		// Streams were told to end along with the HTTP ones.
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcSrv.Stop()
+		}
+	}
+	slog.Info("Server stopped")
 }
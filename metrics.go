@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const metricsContentType = "text/plain; version=0.0.4"
+
+// promWriter emits samples in the Prometheus text exposition format. Every
+// sample carries a host label so several instances can share one scrape job.
+type promWriter struct {
+	w      *bufio.Writer
+	prefix string
+	host   string
+}
+
+// family writes the HELP and TYPE lines that must precede a metric's samples.
+func (p *promWriter) family(name, typ, help string) {
+	p.w.WriteString("# HELP " + p.prefix + "_" + name + " " + help + "\n")
+	p.w.WriteString("# TYPE " + p.prefix + "_" + name + " " + typ + "\n")
+}
+
+// sample writes one sample. labels are alternating key/value pairs that are
+// appended after the host label.
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	p.w.WriteString(p.prefix + "_" + name + `{host="` + escapeLabel(p.host) + `"`)
+	for i := 0; i+1 < len(labels); i += 2 {
+		p.w.WriteString("," + labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+	}
+	p.w.WriteString("} " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// gauge writes a metric family with a single unlabelled sample.
+func (p *promWriter) gauge(name, help string, value float64) {
+	p.family(name, "gauge", help)
+	p.sample(name, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// writeMetrics renders s as Prometheus metrics named <prefix>_<metric>.
+// Families for collectors outside sections, or that failed, are left out so
+// they show up as gaps rather than zeros; collector_up tells the two apart.
+func writeMetrics(w io.Writer, prefix string, sections sectionSet, s *Stats) error {
+	p := &promWriter{w: bufio.NewWriter(w), prefix: prefix, host: s.Hostname}
+	ok := func(collector string) bool { return sections.collected(s, collector) }
+
+	p.family("collector_up", "gauge", "Whether each enabled collector succeeded (1) or failed (0).")
+	for _, c := range collectors {
+		if sections.has(c.name) {
+			up := 1.0
+			if _, failed := s.Errors[c.name]; failed {
+				up = 0
+			}
+			p.sample("collector_up", up, "collector", c.name)
+		}
+	}
+
+	if ok("cpu") {
+		p.gauge("cpu_percent", "Aggregate CPU utilization in percent.", s.CPUPercent)
+		p.family("cpu_core_percent", "gauge", "Per-core CPU utilization in percent.")
+		for i, pct := range s.PerCorePercent {
+			p.sample("cpu_core_percent", pct, "core", strconv.Itoa(i))
+		}
+	}
+
+	if ok("memory") {
+		p.gauge("memory_total_bytes", "Total physical memory in bytes.", float64(s.Memory.Total))
+		p.gauge("memory_used_bytes", "Used physical memory in bytes.", float64(s.Memory.Used))
+		p.gauge("memory_available_bytes", "Available physical memory in bytes.", float64(s.Memory.Available))
+		p.gauge("memory_percent", "Used physical memory in percent.", s.Memory.Percent)
+	}
+
+	if ok("swap") {
+		p.gauge("swap_total_bytes", "Total swap in bytes.", float64(s.Swap.Total))
+		p.gauge("swap_used_bytes", "Used swap in bytes.", float64(s.Swap.Used))
+		p.gauge("swap_percent", "Used swap in percent.", s.Swap.Percent)
+	}
+
+	if ok("disk") {
+		p.family("disk_total_bytes", "gauge", "Total size of the monitored disk in bytes.")
+		p.sample("disk_total_bytes", float64(s.Disk.Total), "mountpoint", s.Disk.Mountpoint)
+		p.family("disk_used_bytes", "gauge", "Used space on the monitored disk in bytes.")
+		p.sample("disk_used_bytes", float64(s.Disk.Used), "mountpoint", s.Disk.Mountpoint)
+		p.family("disk_percent", "gauge", "Used space on the monitored disk in percent.")
+		p.sample("disk_percent", s.Disk.Percent, "mountpoint", s.Disk.Mountpoint)
+	}
+
+	if ok("disks") {
+		p.family("filesystem_total_bytes", "gauge", "Total size of each mounted filesystem in bytes.")
+		for _, d := range s.Disks {
+			p.sample("filesystem_total_bytes", float64(d.Total), "mountpoint", d.Mountpoint, "fstype", d.Fstype)
+		}
+		p.family("filesystem_used_bytes", "gauge", "Used space on each mounted filesystem in bytes.")
+		for _, d := range s.Disks {
+			p.sample("filesystem_used_bytes", float64(d.Used), "mountpoint", d.Mountpoint, "fstype", d.Fstype)
+		}
+		p.family("filesystem_percent", "gauge", "Used space on each mounted filesystem in percent.")
+		for _, d := range s.Disks {
+			p.sample("filesystem_percent", d.Percent, "mountpoint", d.Mountpoint, "fstype", d.Fstype)
+		}
+	}
+
+	if ok("network") {
+		p.family("network_sent_bytes_total", "counter", "Bytes sent across all interfaces, or by NET_INTERFACE alone when set.")
+		p.sample("network_sent_bytes_total", float64(s.Network.BytesSent))
+		p.family("network_received_bytes_total", "counter", "Bytes received across all interfaces, or by NET_INTERFACE alone when set.")
+		p.sample("network_received_bytes_total", float64(s.Network.BytesRecv))
+		p.gauge("network_send_rate_bytes", "Bytes sent per second since the previous sample.", s.Network.SendRate)
+		p.gauge("network_receive_rate_bytes", "Bytes received per second since the previous sample.", s.Network.RecvRate)
+
+		p.family("interface_sent_bytes_total", "counter", "Bytes sent per interface.")
+		for _, nic := range s.Interfaces {
+			p.sample("interface_sent_bytes_total", float64(nic.BytesSent), "interface", nic.Name)
+		}
+		p.family("interface_received_bytes_total", "counter", "Bytes received per interface.")
+		for _, nic := range s.Interfaces {
+			p.sample("interface_received_bytes_total", float64(nic.BytesRecv), "interface", nic.Name)
+		}
+	}
+
+	if ok("host") {
+		p.gauge("uptime_seconds", "Seconds since the host booted.", float64(s.UptimeSeconds))
+	}
+
+	if ok("load") {
+		p.gauge("load1", "1-minute load average.", s.Load.Load1)
+		p.gauge("load5", "5-minute load average.", s.Load.Load5)
+		p.gauge("load15", "15-minute load average.", s.Load.Load15)
+	}
+
+	return p.w.Flush()
+}
+
+// metricsHandler serves the same data as /api/stats in Prometheus format.
+func metricsHandler(cache *statsCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := cache.get(r.Context(), nil)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, context.DeadlineExceeded) {
+				status = http.StatusGatewayTimeout
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", metricsContentType)
+		writeMetrics(w, cache.cfg.MetricsPrefix, cache.cfg.Sections, stats)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// basicAuth requires HTTP basic credentials matching user and pass. The
+// /healthz probe stays open so orchestrators can check liveness without them.
+func basicAuth(next http.Handler, user, pass string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u, p, ok := r.BasicAuth()
+		// Compare both fields unconditionally so timing doesn't reveal which
+		// one was wrong.
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user))
+		passOK := subtle.ConstantTimeCompare([]byte(p), []byte(pass))
+		if !ok || userOK&passOK != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="server-dashboard", charset="UTF-8"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors adds CORS headers. With an empty allowedOrigin any origin is allowed
+// via "*"; otherwise only that exact origin is echoed back and other origins
+// get no CORS headers (preflights from them are refused). Preflight requests
+// are answered directly so they never reach authentication.
+func cors(next http.Handler, allowedOrigin string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := true
+		if allowedOrigin == "" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			allowed = origin == allowedOrigin
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				writeError(w, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// gzipMinSize is the smallest body worth compressing; anything shorter
+// (like the /healthz reply) is sent as-is.
+const gzipMinSize = 1024
+
+// gzipETagSuffix marks the ETag of a compressed body, which must differ
+// from the identity body's since the bytes differ.
+const gzipETagSuffix = "-gz"
+
+// gzipResponseWriter holds back the start of the body until it knows
+// whether the response is big enough to compress.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	buf      []byte
+	gz       *gzip.Writer
+	decided  bool
+	gzTagged bool // If-None-Match named a compressed body
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if !g.decided {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.decided {
+		if g.gz != nil {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= gzipMinSize {
+		if err := g.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush lets streaming handlers push data out immediately. A response that
+// flushes before reaching gzipMinSize is left uncompressed.
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		g.decide(false)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide sends the headers, compressing if wanted and the response allows
+// it, then writes out whatever body was held back.
+func (g *gzipResponseWriter) decide(compress bool) error {
+	g.decided = true
+	h := g.Header()
+	if compress && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		g.status == http.StatusOK && !strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+		tagGzipETag(h)
+	} else if g.status == http.StatusNotModified && g.gzTagged {
+		tagGzipETag(h)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := g.Write(buf)
+	return err
+}
+
+// finish flushes a body that never reached gzipMinSize and closes the
+// gzip stream, if any.
+func (g *gzipResponseWriter) finish() {
+	if !g.decided {
+		g.decide(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+// tagGzipETag appends gzipETagSuffix inside the quotes of h's ETag, if any.
+func tagGzipETag(h http.Header) {
+	if etag := h.Get("ETag"); strings.HasSuffix(etag, `"`) {
+		h.Set("ETag", strings.TrimSuffix(etag, `"`)+gzipETagSuffix+`"`)
+	}
+}
+
+// gzipHandler compresses responses for clients that accept gzip. WebSocket
+// upgrades are passed straight through since they need the raw connection.
+func gzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		// Handlers compare If-None-Match against the identity ETag, so
+		// strip the suffix tagGzipETag added.
+		if inm := r.Header.Get("If-None-Match"); strings.Contains(inm, gzipETagSuffix+`"`) {
+			r = r.Clone(r.Context())
+			r.Header.Set("If-None-Match", strings.ReplaceAll(inm, gzipETagSuffix+`"`, `"`))
+			gw.gzTagged = true
+		}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// statusRecorder captures the status code and body size written by a
+// handler. Handlers that never call WriteHeader implicitly send 200.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.size += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack supports WebSocket upgrades through the recorder.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// logRequests logs the client, method, path, status, response size and
+// duration of every request.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		slog.Info("request",
+			"remote", clientIP(r),
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"status", rec.status,
+			"bytes", rec.size,
+			"duration", time.Since(start).Round(time.Microsecond).String(),
+		)
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500 JSON response and logs
+// the panic with its stack trace, rather than dropping the connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err) // deliberate abort; let net/http handle it quietly
+			}
+			slog.Error("Panic serving request", "method", r.Method, "path", r.URL.Path, "panic", err, "stack", string(debug.Stack()))
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trustProxy makes clientIP believe X-Real-IP and X-Forwarded-For. Only
+// enable it (via TRUST_PROXY) behind a proxy that sets those headers, as
+// clients can otherwise send any value they like.
+var trustProxy bool
+
+// clientIP returns the IP address of the client making r, without the port
+// and with IPv6 brackets removed. With trustProxy set, X-Real-IP is used
+// if present, then the last X-Forwarded-For entry, which is the one our
+// proxy appended; earlier entries come from the client and can be forged.
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return stripPort(ip)
+		}
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			entries := strings.Split(xff[len(xff)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return stripPort(ip)
+			}
+		}
+	}
+	return stripPort(r.RemoteAddr)
+}
+
+// stripPort turns "1.2.3.4:80" into "1.2.3.4" and "[::1]:80" or "[::1]"
+// into "::1". Anything else, such as a Unix socket's empty address, is
+// returned unchanged.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
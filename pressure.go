@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// PSIStats holds the 10-second averages from /proc/pressure/memory: the
+// percentage of time some (or all) tasks were stalled waiting on memory.
+type PSIStats struct {
+	SomeAvg10 float64 `json:"some_avg10"`
+	FullAvg10 float64 `json:"full_avg10"`
+}
+
+// collectMemoryPressure classifies how close the host is to running out of
+// memory:
+//
+//	high    under 10% available, >80% of swap used with under 20% available,
+//	        or PSI some avg10 over 10% / full avg10 over 5%
+//	medium  under 20% available, >50% of swap used, or PSI some avg10 over 1%
+//	low     otherwise
+//
+// PSI needs Linux 4.20+ with CONFIG_PSI; without it only memory and swap
+// usage are considered and MemoryPSI is omitted.
+func collectMemoryPressure(ctx context.Context, cfg *Config, s *Stats) error {
+	vm, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	// As in collectMemory: an unreadable /proc/meminfo comes back as zeros,
+	// which would otherwise read as 100% available.
+	if vm.Total == 0 {
+		return errors.New("no memory figures reported")
+	}
+	availablePct := float64(vm.Available) / float64(vm.Total) * 100
+	swapPct := 0.0
+	if swap, err := mem.SwapMemoryWithContext(ctx); err == nil && swap.Total > 0 {
+		swapPct = swap.UsedPercent
+	}
+	psi, psiOK := readMemoryPSI()
+
+	switch {
+	case availablePct < 10,
+		swapPct > 80 && availablePct < 20,
+		psiOK && (psi.SomeAvg10 > 10 || psi.FullAvg10 > 5):
+		s.MemoryPressure = "high"
+	case availablePct < 20, swapPct > 50, psiOK && psi.SomeAvg10 > 1:
+		s.MemoryPressure = "medium"
+	default:
+		s.MemoryPressure = "low"
+	}
+	if psiOK {
+		s.MemoryPSI = &psi
+	}
+	return nil
+}
+
+// readMemoryPSI parses lines like
+// "some avg10=0.12 avg60=0.05 avg300=0.01 total=12345".
+func readMemoryPSI() (PSIStats, bool) {
+	data, err := os.ReadFile("/proc/pressure/memory")
+	if err != nil {
+		return PSIStats{}, false
+	}
+	var psi PSIStats
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		v, ok := strings.CutPrefix(fields[1], "avg10=")
+		if !ok {
+			continue
+		}
+		avg10, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "some":
+			psi.SomeAvg10, found = avg10, true
+		case "full":
+			psi.FullAvg10 = avg10
+		}
+	}
+	return psi, found
+}
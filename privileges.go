@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// privilegeErr replaces a permission error's raw "operation not permitted"
+// with what needs the privilege, so users know what to fix. It still
+// matches fs.ErrPermission with errors.Is.
+type privilegeErr struct {
+	what string
+	err  error
+}
+
+func (e *privilegeErr) Error() string { return "requires root for " + e.what }
+func (e *privilegeErr) Unwrap() error { return e.err }
+
+// privilegeError translates err if it is a permission error and returns
+// any other error unchanged.
+func privilegeError(err error, what string) error {
+	var pe *privilegeErr
+	if !permissionDenied(err) || errors.As(err, &pe) {
+		return err
+	}
+	return &privilegeErr{what: what, err: err}
+}
+
+// permissionDenied reports whether err is, or for gopsutil's warning lists
+// contains, a permission error.
+func permissionDenied(err error) bool {
+	if errors.Is(err, fs.ErrPermission) {
+		return true
+	}
+	if warns, ok := err.(*host.Warnings); ok {
+		for _, e := range warns.List {
+			if errors.Is(e, fs.ErrPermission) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+const defaultTopProcesses = 10
+
+type ProcessStats struct {
+	PID           int32   `json:"pid"`
+	Name          string  `json:"name"`
+	Running       bool    `json:"running"`
+	Count         int     `json:"count,omitempty"` // matching processes, for WATCH_PROCESS
+	CPUPercent    float64 `json:"cpu_percent"`     // of one core, since the previous scan
+	MemoryPercent float64 `json:"memory_percent"`
+	RSS           uint64  `json:"rss"`
+	NumThreads    int32   `json:"num_threads,omitempty"`
+}
+
+// topProcesses returns the n heaviest processes, sorted descending by CPU
+// (or by memory when byMemory is set). Enumerating every process is
+// expensive, so this is only run on request rather than in getStats.
+//
+// Processes that exit or can't be inspected mid-scan are skipped.
+func topProcesses(ctx context.Context, n int, byMemory bool, places int) ([]ProcessStats, error) {
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	memInfo, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cpuPcts, err := topCPU.sample(ctx, procs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ProcessStats, 0, len(procs))
+	for _, p := range procs {
+		cpuPct, ok := cpuPcts[p.Pid]
+		if !ok {
+			continue // exited, or its CPU times can't be read
+		}
+		name, err := p.NameWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		var rss uint64
+		if info, err := p.MemoryInfoWithContext(ctx); err == nil {
+			rss = info.RSS
+		}
+		result = append(result, ProcessStats{
+			PID:           p.Pid,
+			Name:          name,
+			Running:       true,
+			CPUPercent:    round(cpuPct, places),
+			MemoryPercent: round(memoryPercent(rss, memInfo.Total), places),
+			RSS:           rss,
+		})
+	}
+
+	// Sort by the primary metric, breaking ties with the other one.
+	keys := func(p ProcessStats) (float64, float64) {
+		if byMemory {
+			return p.MemoryPercent, p.CPUPercent
+		}
+		return p.CPUPercent, p.MemoryPercent
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a1, a2 := keys(result[i])
+		b1, b2 := keys(result[j])
+		if a1 != b1 {
+			return a1 > b1
+		}
+		return a2 > b2
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result, nil
+}
+
+// processStates counts processes by scheduler state ("running", "sleep",
+// "zombie", "stop", ...), as named by gopsutil. Like topProcesses it reads
+// every process, so it is only run on request. Processes that exit
+// mid-scan are skipped; ones whose state can't be read count as "unknown".
+func processStates(ctx context.Context) (map[string]int, error) {
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, p := range procs {
+		status, err := p.StatusWithContext(ctx)
+		if err != nil {
+			if running, rerr := p.IsRunningWithContext(ctx); rerr == nil && !running {
+				continue // exited since Processes
+			}
+			counts["unknown"]++
+			continue
+		}
+		state := "unknown"
+		if len(status) > 0 && status[0] != process.UnknownState {
+			state = status[0]
+		}
+		counts[state]++
+	}
+	return counts, nil
+}
+
+// procCPUWindow is how long the first scan by a procCPUTracker waits
+// between readings; later scans measure since the one before.
+const procCPUWindow = 500 * time.Millisecond
+
+// minProcCPUDelta is the shortest gap between scans worth measuring; the
+// counters tick in hundredths of a second, so closer scans repeat the
+// previous result.
+const minProcCPUDelta = 250 * time.Millisecond
+
+// procCPUTracker turns per-process cumulative CPU times into current usage.
+// gopsutil's CPUPercent averages over a process's whole lifetime, which
+// ranks long-lived idle daemons above whatever is busy now. Each scan is
+// compared with the previous one, like cpuTimesTracker does for the host.
+type procCPUTracker struct {
+	mu      sync.Mutex
+	at      time.Time
+	entries map[int32]procCPUEntry // by PID, from the previous scan
+}
+
+type procCPUEntry struct {
+	cpu     float64 // user + system seconds
+	created int64   // creation time, so a reused PID isn't compared with its predecessor
+	pct     float64
+}
+
+// Separate trackers, since each scan replaces the previous one's entries
+// and the two scan different sets of processes.
+var topCPU, watchedCPU procCPUTracker
+
+// sample returns the CPU usage of procs, in percent of one core, keyed by
+// PID. Processes that can't be read are left out; ones that started since
+// the previous scan report 0 until the next.
+func (t *procCPUTracker) sample(ctx context.Context, procs []*process.Process) (map[int32]float64, error) {
+	t.mu.Lock()
+	primed := t.entries != nil
+	t.mu.Unlock()
+	if !primed {
+		t.update(readProcCPU(ctx, procs), time.Now())
+		timer := time.NewTimer(procCPUWindow)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+	return t.update(readProcCPU(ctx, procs), time.Now()), nil
+}
+
+func readProcCPU(ctx context.Context, procs []*process.Process) map[int32]procCPUEntry {
+	cur := make(map[int32]procCPUEntry, len(procs))
+	for _, p := range procs {
+		times, err := p.TimesWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		created, _ := p.CreateTimeWithContext(ctx)
+		cur[p.Pid] = procCPUEntry{cpu: times.User + times.System, created: created}
+	}
+	return cur
+}
+
+func (t *procCPUTracker) update(cur map[int32]procCPUEntry, now time.Time) map[int32]float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pcts := make(map[int32]float64, len(cur))
+	if t.entries != nil && now.Sub(t.at) < minProcCPUDelta {
+		for pid, c := range cur {
+			if prev, ok := t.entries[pid]; ok && prev.created == c.created {
+				c.pct = prev.pct
+			}
+			pcts[pid] = c.pct
+		}
+		return pcts
+	}
+	elapsed := now.Sub(t.at).Seconds()
+	for pid, c := range cur {
+		if prev, ok := t.entries[pid]; ok && prev.created == c.created {
+			c.pct = max(c.cpu-prev.cpu, 0) / elapsed * 100
+			cur[pid] = c
+		}
+		pcts[pid] = c.pct
+	}
+	t.entries, t.at = cur, now
+	return pcts
+}
+
+func memoryPercent(rss, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(rss) / float64(total) * 100
+}
+
+// collectWatchedProcess reports the process named cfg.WatchProcess, summing
+// across all processes with that name (e.g. an nginx master and its
+// workers). PID is that of the first match.
+func collectWatchedProcess(ctx context.Context, cfg *Config, s *Stats) error {
+	if cfg.WatchProcess == "" {
+		return nil
+	}
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	memInfo, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var matches []*process.Process
+	for _, p := range procs {
+		if name, err := p.NameWithContext(ctx); err == nil && name == cfg.WatchProcess {
+			matches = append(matches, p)
+		}
+	}
+	cpuPcts, err := watchedCPU.sample(ctx, matches)
+	if err != nil {
+		return err
+	}
+
+	w := &ProcessStats{Name: cfg.WatchProcess}
+	var cpuPct float64
+	for _, p := range matches {
+		if w.Count == 0 {
+			w.PID = p.Pid
+		}
+		w.Count++
+		cpuPct += cpuPcts[p.Pid]
+		if info, err := p.MemoryInfoWithContext(ctx); err == nil {
+			w.RSS += info.RSS
+		}
+		if n, err := p.NumThreadsWithContext(ctx); err == nil {
+			w.NumThreads += n
+		}
+	}
+	w.Running = w.Count > 0
+	w.CPUPercent = round(cpuPct, cfg.Precision)
+	w.MemoryPercent = round(memoryPercent(w.RSS, memInfo.Total), cfg.Precision)
+	s.WatchedProcess = w
+	return nil
+}
+
+// collectProcessCounts counts processes and threads. On Linux the thread
+// total comes cheaply from /proc/loadavg; elsewhere each process's thread
+// count is summed.
+func collectProcessCounts(ctx context.Context, cfg *Config, s *Stats) error {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	s.ProcessCount = len(pids)
+
+	if threads, ok := procThreadCount(); ok {
+		s.ThreadCount = threads
+		return nil
+	}
+	for _, pid := range pids {
+		p, err := process.NewProcessWithContext(ctx, pid)
+		if err != nil {
+			continue // exited since Pids
+		}
+		if n, err := p.NumThreadsWithContext(ctx); err == nil {
+			s.ThreadCount += int(n)
+		}
+	}
+	return nil
+}
+
+// procThreadCount reads the total number of kernel scheduling entities
+// (threads) from the "running/total" field of /proc/loadavg.
+func procThreadCount() (int, bool) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 4 {
+		return 0, false
+	}
+	_, total, ok := strings.Cut(fields[3], "/")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(total)
+	return n, err == nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// procMissing is set in main when probeProc fails. getStats then answers
+// with whatever it could collect, every failure listed in Errors, rather
+// than failing outright when no collector succeeds: in a sandbox that
+// hides /proc that is every time, and an endpoint that only ever returns
+// 500 is less useful than a mostly empty one.
+var procMissing bool
+
+// probeProc checks that /proc (or HOST_PROC) is readable, which nearly
+// every Linux collector relies on.
+func probeProc(ctx context.Context) error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	_, err := host.BootTimeWithContext(ctx)
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// pusher sends every sample to a Prometheus Pushgateway, grouped under a
+// job named after the host. It is a sampler sink.
+type pusher struct {
+	url      string     // gateway base URL
+	prefix   string     // metric name prefix, as on /metrics
+	sections sectionSet // SECTIONS, as on /metrics
+	client   *http.Client
+	busy     atomic.Bool
+}
+
+func newPusher(gatewayURL, prefix string, sections sectionSet) *pusher {
+	return &pusher{
+		url:      strings.TrimSuffix(gatewayURL, "/"),
+		prefix:   prefix,
+		sections: sections,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// push formats s like /metrics and posts it in the background. If the
+// previous push is still in flight the sample is dropped rather than
+// letting requests to a slow gateway pile up.
+func (p *pusher) push(s *Stats) {
+	if !p.busy.CompareAndSwap(false, true) {
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeMetrics(&buf, p.prefix, p.sections, s); err != nil {
+		p.busy.Store(false)
+		slog.Error("Push failed", "err", err)
+		return
+	}
+	go func() {
+		defer p.busy.Store(false)
+		target := p.url + "/metrics/job/" + url.PathEscape(s.Hostname)
+		resp, err := p.client.Post(target, metricsContentType, &buf)
+		if err != nil {
+			slog.Error("Push failed", "err", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			slog.Error("Push failed", "url", target, "status", resp.Status)
+		}
+	}()
+}
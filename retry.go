@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	maxCollectorRetries = 5
+	maxRetryDelay       = time.Second // cap on any single backoff
+)
+
+// withRetry calls fn until it succeeds or has been retried retries times,
+// sleeping delay before the first retry and doubling it, up to
+// maxRetryDelay, before each one after. Partial results, permission
+// errors and cancellation are returned at once since retrying won't
+// change them, and so is the last error when the next sleep would
+// outlast ctx's deadline: a slow retry is worse than a partial error.
+func withRetry(ctx context.Context, retries int, delay time.Duration, fn func() error) error {
+	err := fn()
+	for i := 0; i < retries && err != nil && retryable(err); i++ {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			break
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		delay = min(delay*2, maxRetryDelay)
+		err = fn()
+	}
+	return err
+}
+
+func retryable(err error) bool {
+	_, partial := err.(partialErrors)
+	return !partial && !permissionDenied(err) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// sampler collects stats on a fixed interval from a single goroutine and
+// fans each sample out to every subscriber, so N streaming clients cost the
+// same as one. Sinks receive every sample too and keep the sampler running,
+// unless SAMPLER_MODE is "on-demand"; either way, with nothing to keep it
+// running it pauses while nobody is subscribed.
+type sampler struct {
+	cfg      *Config
+	interval time.Duration
+	sinks    []func(*Stats)
+	onDemand bool          // only sample while someone is subscribed
+	wake     chan struct{} // signalled by subscribe to resume a paused sampler
+
+	mu   sync.Mutex
+	subs map[chan *Stats]struct{}
+}
+
+func newSampler(cfg *Config) *sampler {
+	return &sampler{
+		cfg:      cfg,
+		interval: cfg.SampleInterval,
+		onDemand: cfg.SamplerMode == "on-demand",
+		wake:     make(chan struct{}, 1),
+		subs:     make(map[chan *Stats]struct{}),
+	}
+}
+
+// addSink registers fn to be called with every sample. It must be called
+// before run.
+func (s *sampler) addSink(fn func(*Stats)) {
+	s.sinks = append(s.sinks, fn)
+}
+
+// run samples until ctx is cancelled.
+func (s *sampler) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if s.idle() {
+			ticker.Stop()
+			slog.Debug("Sampler paused")
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			}
+			slog.Debug("Sampler resumed")
+			ticker.Reset(s.interval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if s.idle() {
+			continue // the last subscriber left during the tick
+		}
+		sampleCtx, cancel := context.WithTimeout(ctx, s.cfg.StatsTimeout)
+		stats, err := getStats(sampleCtx, s.cfg, nil)
+		cancel()
+		if ctx.Err() != nil {
+			return // shutting down
+		}
+		if err != nil {
+			slog.Error("Sampler failed", "err", err)
+			continue
+		}
+		for _, sink := range s.sinks {
+			sink(stats)
+		}
+		s.publish(stats)
+	}
+}
+
+// subscribe registers a new subscriber. The returned function must be
+// called to unsubscribe once the caller stops reading.
+func (s *sampler) subscribe() (<-chan *Stats, func()) {
+	ch := make(chan *Stats, 1)
+
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default: // already signalled
+	}
+
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}
+}
+
+// idle reports whether there is currently no reason to take samples.
+func (s *sampler) idle() bool {
+	return s.subscribers() == 0 && (s.onDemand || len(s.sinks) == 0)
+}
+
+func (s *sampler) subscribers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs)
+}
+
+// publish hands stats to every subscriber without blocking. A subscriber
+// that hasn't consumed the previous sample yet simply misses this one.
+func (s *sampler) publish(stats *Stats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- stats:
+		default:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// connCounter counts open client connections, for logging at shutdown. It
+// is an http.Server ConnState hook. Hijacked connections (WebSockets) are
+// no longer the server's, so they are counted by streamTracker instead.
+type connCounter struct {
+	n atomic.Int64
+}
+
+func (c *connCounter) track(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		c.n.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		c.n.Add(-1)
+	}
+}
+
+func (c *connCounter) active() int64 { return c.n.Load() }
+
+// streamTracker ends WebSocket and SSE streams at shutdown. Shutdown
+// would otherwise wait out its whole timeout on SSE requests, which never
+// finish by themselves, and doesn't know about hijacked WebSockets at all.
+type streamTracker struct {
+	n       atomic.Int64
+	once    sync.Once
+	closing chan struct{}
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{closing: make(chan struct{})}
+}
+
+// start registers a stream; call the returned func when it ends.
+func (t *streamTracker) start() (done func()) {
+	t.n.Add(1)
+	return func() { t.n.Add(-1) }
+}
+
+// close tells every stream to end. It is safe to call more than once.
+func (t *streamTracker) close() {
+	t.once.Do(func() { close(t.closing) })
+}
+
+func (t *streamTracker) active() int64 { return t.n.Load() }
@@ -0,0 +1,1152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+type Stats struct {
+	Hostname         string           `json:"hostname"`
+	CPUPercent       float64          `json:"cpu_percent"`
+	PerCorePercent   []float64        `json:"per_core_percent"`
+	CPULimit         float64          `json:"cpu_limit,omitempty"` // container CPU quota in cores; CPUPercent is then of this
+	CPUTimes         *CPUTimesStats   `json:"cpu_times,omitempty"` // omitted until there is a previous sample
+	CPUInfo          CPUInfo          `json:"cpu_info"`
+	Memory           MemoryStats      `json:"memory"`
+	Swap             SwapStats        `json:"swap"`
+	MemoryPressure   string           `json:"memory_pressure"` // "low", "medium" or "high"
+	MemoryPSI        *PSIStats        `json:"memory_psi,omitempty"`
+	Disk             DiskStats        `json:"disk"`
+	DiskLow          bool             `json:"disk_low"` // Disk is above DISK_ALERT_PCT or below DISK_MIN_FREE_BYTES free
+	Disks            []DiskStats      `json:"disks"`
+	DiskIO           DiskIOStats      `json:"disk_io"`
+	Network          NetworkStats     `json:"network"`
+	Interfaces       []InterfaceStats `json:"interfaces"`
+	Load             LoadStats        `json:"load"`
+	Temperatures     []SensorStats    `json:"temperatures"`
+	MaxTemperature   float64          `json:"max_temperature,omitempty"` // hottest sensor; omitted without sensors
+	ThermalWarning   bool             `json:"thermal_warning"`           // a sensor is above its High or TEMP_WARN_C
+	Fans             []FanStats       `json:"fans"`
+	Battery          BatteryStats     `json:"battery"`
+	GPUs             []GPUStats       `json:"gpus,omitempty"`
+	Uptime           string           `json:"uptime"`
+	UptimeSeconds    uint64           `json:"uptime_seconds"`
+	System           SystemInfo       `json:"system"`
+	Users            []UserStats      `json:"users"`
+	UserCount        int              `json:"user_count"`
+	ProcessCount     int              `json:"process_count"`
+	ThreadCount      int              `json:"thread_count"`
+	OpenFiles        uint64           `json:"open_files"`
+	MaxFiles         uint64           `json:"max_files"`
+	OpenFilesPercent float64          `json:"open_files_percent"`
+	WatchedProcess   *ProcessStats    `json:"watched_process,omitempty"`
+	TopProcesses     []ProcessStats   `json:"top_processes,omitempty"`
+	ProcessStates    map[string]int   `json:"process_states,omitempty"` // state -> count, on request
+	Anomalies        []string         `json:"anomalies,omitempty"`      // metrics far outside their recent range; see anomalies.go
+	Timestamp        time.Time        `json:"timestamp"`
+	// CollectionDurationMs is how long getStats took, so a slowing host
+	// (or a collector stuck on, say, a hung mount) shows up.
+	CollectionDurationMs float64           `json:"collection_duration_ms"`
+	Errors               map[string]string `json:"errors,omitempty"` // failed collector name -> error
+}
+
+type MemoryStats struct {
+	Total     uint64  `json:"total"`
+	Used      uint64  `json:"used"`
+	Percent   float64 `json:"percent"`
+	Available uint64  `json:"available"`
+	Free      uint64  `json:"free"`
+	// Cached and Buffers are only reported on some platforms (e.g. Linux)
+	// and are omitted where gopsutil leaves them unset.
+	Cached  uint64 `json:"cached,omitempty"`
+	Buffers uint64 `json:"buffers,omitempty"`
+	// Container is set when the figures are scoped to a container's cgroup
+	// memory limit rather than the host (see CONTAINER_AWARE).
+	Container bool `json:"container,omitempty"`
+}
+
+type SwapStats struct {
+	Total   uint64  `json:"total"`
+	Used    uint64  `json:"used"`
+	Free    uint64  `json:"free"`
+	Percent float64 `json:"percent"`
+}
+
+type DiskStats struct {
+	Mountpoint string  `json:"mountpoint"`
+	Fstype     string  `json:"fstype"`
+	Total      uint64  `json:"total"`
+	Used       uint64  `json:"used"`
+	Free       uint64  `json:"free"` // available to unprivileged users
+	Percent    float64 `json:"percent"`
+
+	InodesTotal       uint64  `json:"inodes_total"`
+	InodesUsed        uint64  `json:"inodes_used"`
+	InodesUsedPercent float64 `json:"inodes_used_percent"`
+}
+
+// pseudoFilesystems are skipped when enumerating mounted filesystems since
+// they don't represent real storage.
+var pseudoFilesystems = map[string]bool{
+	"tmpfs":    true,
+	"devtmpfs": true,
+	"squashfs": true,
+}
+
+type NetworkStats struct {
+	BytesSent uint64  `json:"bytes_sent"`
+	BytesRecv uint64  `json:"bytes_recv"`
+	SendRate  float64 `json:"send_rate"` // bytes/sec since the previous sample
+	RecvRate  float64 `json:"recv_rate"` // bytes/sec since the previous sample
+}
+
+type InterfaceStats struct {
+	Name        string `json:"name"`
+	Loopback    bool   `json:"loopback"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	PacketsSent uint64 `json:"packets_sent"`
+	PacketsRecv uint64 `json:"packets_recv"`
+	Errin       uint64 `json:"errin"`
+	Errout      uint64 `json:"errout"`
+	Dropin      uint64 `json:"dropin"`
+	Dropout     uint64 `json:"dropout"`
+	Speed       int64  `json:"speed_mbps,omitempty"` // negotiated link speed; omitted when unknown
+	// Per-second rates since the previous sample. A rising error or drop
+	// rate points at a failing NIC or an overloaded link.
+	ErrinRate   float64 `json:"errin_rate"`
+	ErroutRate  float64 `json:"errout_rate"`
+	DropinRate  float64 `json:"dropin_rate"`
+	DropoutRate float64 `json:"dropout_rate"`
+}
+
+type DiskIOStats struct {
+	// The aggregate only sums whole devices so partitions aren't counted twice.
+	ReadBytesRate  float64         `json:"read_bytes_rate"`
+	WriteBytesRate float64         `json:"write_bytes_rate"`
+	ReadOps        float64         `json:"read_ops"` // operations per second
+	WriteOps       float64         `json:"write_ops"`
+	Devices        []DeviceIOStats `json:"devices"`
+}
+
+type DeviceIOStats struct {
+	Name           string  `json:"name"`
+	Mountpoint     string  `json:"mountpoint,omitempty"` // where the device is mounted, if it is
+	ReadBytesRate  float64 `json:"read_bytes_rate"`
+	WriteBytesRate float64 `json:"write_bytes_rate"`
+	ReadOps        float64 `json:"read_ops"`
+	WriteOps       float64 `json:"write_ops"`
+}
+
+// CPUInfo describes the processor. It doesn't change at runtime, so it is
+// read once at startup.
+type CPUInfo struct {
+	PhysicalCores int     `json:"physical_cores"`
+	LogicalCores  int     `json:"logical_cores"`
+	ModelName     string  `json:"model_name"`
+	Mhz           float64 `json:"mhz"`
+}
+
+type LoadStats struct {
+	Load1         float64 `json:"1min"`
+	Load5         float64 `json:"5min"`
+	Load15        float64 `json:"15min"`
+	Load1PerCore  float64 `json:"1min_per_core"`
+	Load5PerCore  float64 `json:"5min_per_core"`
+	Load15PerCore float64 `json:"15min_per_core"`
+	// Load1Trend is "positive", "negative" or "stable" going by Load1Slope,
+	// the change in Load1 per minute since the sample about a minute ago.
+	// Both are omitted until the history holds such a sample.
+	Load1Trend string   `json:"1min_trend,omitempty"`
+	Load1Slope *float64 `json:"1min_slope,omitempty"`
+}
+
+type SystemInfo struct {
+	OS              string `json:"os"`
+	Platform        string `json:"platform"`
+	PlatformVersion string `json:"platform_version"`
+	KernelVersion   string `json:"kernel_version"`
+	KernelArch      string `json:"kernel_arch"`
+	BootTime        string `json:"boot_time"` // RFC3339
+}
+
+type UserStats struct {
+	User     string `json:"user"`
+	Terminal string `json:"terminal"`
+	Host     string `json:"host"`
+	Started  string `json:"started"` // RFC3339
+}
+
+type SensorStats struct {
+	SensorKey   string  `json:"sensor_key"`
+	Temperature float64 `json:"temperature"`
+	// Thresholds are omitted when the sensor doesn't report them.
+	High     float64 `json:"high,omitempty"`
+	Critical float64 `json:"critical,omitempty"`
+}
+
+// round rounds value to the given number of decimal places, halves away
+// from zero (so 49.95 becomes 50.0 at one place).
+func round(value float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(value*p) / p
+}
+
+// collector fills in one section of Stats. Each collector only writes its
+// own fields, so all of them can run concurrently against the same *Stats.
+// keys lists the JSON fields it fills, for filtering responses by section.
+type collector struct {
+	name    string
+	collect func(ctx context.Context, cfg *Config, s *Stats) error
+	keys    []string
+}
+
+var collectors = []collector{
+	{"cpu", collectCPU, []string{"cpu_percent", "per_core_percent", "cpu_limit", "cpu_times", "cpu_info"}},
+	{"memory", collectMemory, []string{"memory"}},
+	{"swap", collectSwap, []string{"swap"}},
+	{"pressure", collectMemoryPressure, []string{"memory_pressure", "memory_psi"}},
+	{"disk", collectDisk, []string{"disk", "disk_low"}},
+	{"disks", collectDisks, []string{"disks"}},
+	{"diskio", collectDiskIO, []string{"disk_io"}},
+	{"network", collectNetwork, []string{"network", "interfaces"}},
+	{"load", collectLoad, []string{"load"}},
+	{"host", collectHost, []string{"uptime", "uptime_seconds", "system"}},
+	{"temperatures", collectTemperatures, []string{"temperatures", "max_temperature", "thermal_warning"}},
+	{"fans", collectFans, []string{"fans"}},
+	{"battery", collectBattery, []string{"battery"}},
+	{"gpus", collectGPUs, []string{"gpus"}},
+	{"users", collectUsers, []string{"users", "user_count"}},
+	{"processes", collectProcessCounts, []string{"process_count", "thread_count"}},
+	{"watch", collectWatchedProcess, []string{"watched_process"}},
+	{"files", collectFileDescriptors, []string{"open_files", "max_files", "open_files_percent"}},
+}
+
+// sectionSet selects collectors by name. A nil set selects all of them.
+type sectionSet map[string]bool
+
+// sectionAliases are accepted in place of the collector names they map to.
+var sectionAliases = map[string]string{"uptime": "host"}
+
+// parseSections parses a comma-separated list of collector names. Unknown
+// names are ignored; an empty list yields nil, i.e. every section.
+func parseSections(list string) sectionSet {
+	if list == "" {
+		return nil
+	}
+	known := make(map[string]bool, len(collectors))
+	for _, c := range collectors {
+		known[c.name] = true
+	}
+	set := sectionSet{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if alias, ok := sectionAliases[name]; ok {
+			name = alias
+		}
+		if known[name] {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+func (set sectionSet) has(name string) bool {
+	return set == nil || set[name]
+}
+
+// collected reports whether s holds a reading from the named collector:
+// one in the set that didn't fail. Other sections are zero in s.
+func (set sectionSet) collected(s *Stats, name string) bool {
+	_, failed := s.Errors[name]
+	return set.has(name) && !failed
+}
+
+// within narrows set to the sections in allowed, nil meaning all of them
+// for either.
+func (set sectionSet) within(allowed sectionSet) sectionSet {
+	if allowed == nil {
+		return set
+	}
+	if set == nil {
+		return allowed
+	}
+	narrowed := sectionSet{}
+	for name := range set {
+		if allowed[name] {
+			narrowed[name] = true
+		}
+	}
+	return narrowed
+}
+
+// key identifies the set for caching; all sections share the key "".
+func (set sectionSet) key() string {
+	if set == nil {
+		return ""
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "sections=" + strings.Join(names, ",")
+}
+
+// filter returns s as a JSON object holding only the fields of the selected
+// sections, plus the hostname, timestamp and any errors.
+func (set sectionSet) filter(s *Stats) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	keep := map[string]bool{"hostname": true, "timestamp": true, "errors": true, "collection_duration_ms": true, "top_processes": true, "process_states": true, "anomalies": true}
+	for _, c := range collectors {
+		if set.has(c.name) {
+			for _, key := range c.keys {
+				keep[key] = true
+			}
+		}
+	}
+	for key := range all {
+		if !keep[key] {
+			delete(all, key)
+		}
+	}
+	return all, nil
+}
+
+// view returns s restricted to the set, for encoding as JSON: s itself for
+// a nil set, otherwise what filter returns.
+func (set sectionSet) view(s *Stats) (any, error) {
+	if set == nil {
+		return s, nil
+	}
+	return set.filter(s)
+}
+
+// partialErrors is returned by a collector that gathered some of its items
+// but not others, keyed by item (e.g. a disk path). getStats records each
+// entry in Stats.Errors as "collector:key" without counting the collector as
+// failed.
+type partialErrors map[string]error
+
+func (p partialErrors) Error() string {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	msgs := make([]string, len(keys))
+	for i, key := range keys {
+		msgs[i] = key + ": " + p[key].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// getStats runs the collectors for the selected sections concurrently. The
+// CPU sample blocks for cfg.CPUSampleInterval, so the total latency is
+// roughly that of the slowest collector.
+//
+// Failing collectors are recorded in Stats.Errors and the rest of the stats
+// are still returned; an error is only returned if every collector failed,
+// or ctx is done before they all finish. Collectors still running at that
+// point are abandoned and their results discarded.
+func getStats(ctx context.Context, cfg *Config, sections sectionSet) (*Stats, error) {
+	start := time.Now()
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	stats := &Stats{Hostname: hostname}
+
+	sections = sections.within(cfg.Sections)
+	var run []collector
+	for _, c := range collectors {
+		if sections.has(c.name) {
+			run = append(run, c)
+		}
+	}
+	retries := cfg.CollectorRetries
+	if procMissing {
+		retries = 0 // failures without /proc aren't transient
+	}
+	errs := make([]error, len(run))
+	var wg sync.WaitGroup
+	for i, c := range run {
+		wg.Add(1)
+		go func(i int, c collector) {
+			defer wg.Done()
+			errs[i] = withRetry(ctx, retries, cfg.CollectorRetryDelay, func() error {
+				return c.collect(ctx, cfg, stats)
+			})
+		}(i, c)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var failed []error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if stats.Errors == nil {
+			stats.Errors = make(map[string]string)
+		}
+		if partial, ok := err.(partialErrors); ok {
+			for key, e := range partial {
+				stats.Errors[run[i].name+":"+key] = privilegeError(e, run[i].name+" stats").Error()
+			}
+			continue
+		}
+		err = privilegeError(err, run[i].name+" stats")
+		stats.Errors[run[i].name] = err.Error()
+		failed = append(failed, fmt.Errorf("%s: %w", run[i].name, err))
+	}
+	if len(run) > 0 && len(failed) == len(run) && !procMissing {
+		return nil, fmt.Errorf("all collectors failed: %w", errors.Join(failed...))
+	}
+	if recentHistory != nil {
+		stats.Anomalies = findAnomalies(recentHistory, stats, sections, cfg.AnomalySigma, time.Now())
+	}
+	stats.Timestamp = time.Now()
+	stats.CollectionDurationMs = round(float64(stats.Timestamp.Sub(start))/float64(time.Millisecond), cfg.Precision)
+	return stats, nil
+}
+
+// collectCPU takes a single per-core sample over cfg.CPUSampleInterval, or
+// uses the latest background sample when CPU prewarming is on; the
+// aggregate is the mean across cores.
+func collectCPU(ctx context.Context, cfg *Config, s *Stats) error {
+	s.CPUInfo = cpuInfo
+	var perCore []float64
+	ok := false
+	if cfg.CPUPrewarmInterval > 0 {
+		perCore, ok = cpuPrewarm.latest()
+	}
+	if !ok {
+		var err error
+		if cfg.CPUSampleInterval == 0 {
+			// Usage since gopsutil's previous reading: nothing to wait
+			// for, so no reason to queue for a sample slot.
+			perCore, err = cpu.PercentWithContext(ctx, 0, true)
+		} else {
+			perCore, err = cpuSamples.percent(ctx, cfg.CPUSampleInterval)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// gopsutil skips an unreadable /proc/stat rather than failing.
+	if len(perCore) == 0 {
+		return errors.New("no CPU usage reported")
+	}
+	total := 0.0
+	s.PerCorePercent = make([]float64, len(perCore))
+	for i, pct := range perCore {
+		total += pct
+		s.PerCorePercent[i] = round(pct, cfg.Precision)
+	}
+	total /= float64(len(perCore))
+	s.CPUPercent = round(total, cfg.Precision)
+	times, err := cpuTimes.percent(ctx, cfg.Precision)
+	if err != nil {
+		return err
+	}
+	s.CPUTimes = times
+
+	// Per-core figures stay host-wide: a quota doesn't pin us to cores.
+	if cgroupLimits != nil {
+		if limit := cgroupLimits.cpuLimit(); limit > 0 {
+			s.CPULimit = round(limit, cfg.Precision)
+			if pct, ok := cgroupLimits.cpuPercent(limit); ok {
+				s.CPUPercent = round(pct, cfg.Precision)
+			}
+		}
+	}
+	return nil
+}
+
+func collectMemory(ctx context.Context, cfg *Config, s *Stats) error {
+	memInfo, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	// An unreadable /proc/meminfo comes back as zeros and a NaN percent.
+	if memInfo.Total == 0 {
+		return errors.New("no memory figures reported")
+	}
+	s.Memory = MemoryStats{
+		Total:     memInfo.Total,
+		Used:      memInfo.Used,
+		Percent:   round(memInfo.UsedPercent, cfg.Precision),
+		Available: memInfo.Available,
+		Free:      memInfo.Free,
+		Cached:    memInfo.Cached,
+		Buffers:   memInfo.Buffers,
+	}
+	if cgroupLimits != nil {
+		if limit, used, ok := cgroupLimits.memory(memInfo.Total); ok {
+			used = min(used, limit)
+			s.Memory = MemoryStats{
+				Total:     limit,
+				Used:      used,
+				Percent:   round(float64(used)/float64(limit)*100, cfg.Precision),
+				Available: limit - used,
+				Free:      limit - used,
+				Container: true,
+			}
+		}
+	}
+	return nil
+}
+
+func collectSwap(ctx context.Context, cfg *Config, s *Stats) error {
+	swapInfo, err := mem.SwapMemoryWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	// With swap disabled everything stays zero rather than risking a NaN percent.
+	if swapInfo.Total > 0 {
+		s.Swap = SwapStats{
+			Total:   swapInfo.Total,
+			Used:    swapInfo.Used,
+			Free:    swapInfo.Free,
+			Percent: round(swapInfo.UsedPercent, cfg.Precision),
+		}
+	}
+	return nil
+}
+
+func newDiskStats(usage *disk.UsageStat, places int) DiskStats {
+	d := DiskStats{
+		Mountpoint:  usage.Path,
+		Fstype:      usage.Fstype,
+		Total:       usage.Total,
+		Used:        usage.Used,
+		Free:        usage.Free,
+		Percent:     round(usage.UsedPercent, places),
+		InodesTotal: usage.InodesTotal,
+		InodesUsed:  usage.InodesUsed,
+	}
+	// Filesystems without inodes (or platforms that don't report them) keep
+	// a zero percent rather than a NaN.
+	if usage.InodesTotal > 0 {
+		d.InodesUsedPercent = round(usage.InodesUsedPercent, places)
+	}
+	return d
+}
+
+func collectDisk(ctx context.Context, cfg *Config, s *Stats) error {
+	diskInfo, err := disk.UsageWithContext(ctx, cfg.DiskPath)
+	if err != nil {
+		if _, statErr := os.Stat(cfg.DiskPath); errors.Is(statErr, fs.ErrNotExist) {
+			return fmt.Errorf("path %s does not exist", cfg.DiskPath)
+		}
+		return fmt.Errorf("%s: %w", cfg.DiskPath, err)
+	}
+	s.Disk = newDiskStats(diskInfo, cfg.Precision)
+	if partitions, err := disk.PartitionsWithContext(ctx, false); err == nil {
+		if fstype, ok := mountFstype(partitions, cfg.DiskPath); ok {
+			s.Disk.Fstype = fstype
+		}
+	}
+	s.DiskLow = diskLow(cfg, s.Disk)
+	return nil
+}
+
+// diskLow reports whether d breaches either disk threshold. A percentage
+// alone misjudges large volumes, where 95% used can still leave terabytes.
+func diskLow(cfg *Config, d DiskStats) bool {
+	if cfg.DiskAlertPct > 0 && d.Percent >= cfg.DiskAlertPct {
+		return true
+	}
+	return cfg.DiskMinFreeBytes > 0 && d.Free < cfg.DiskMinFreeBytes
+}
+
+// collectDisks reports usage for every mounted, non-pseudo filesystem, or
+// only for cfg.DiskPaths when that is set. Auto-discovered mounts that can't
+// be read (e.g. permission denied) are skipped silently; explicit paths that
+// fail are reported as partial errors.
+func collectDisks(ctx context.Context, cfg *Config, s *Stats) error {
+	if len(cfg.DiskPaths) > 0 {
+		return collectDiskPaths(ctx, cfg.DiskPaths, cfg.Precision, s)
+	}
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return err
+	}
+	disks := make([]DiskStats, 0, len(partitions))
+	seen := map[string]bool{}
+	for _, p := range partitions {
+		if pseudoFilesystems[p.Fstype] || seen[p.Mountpoint] || diskExcluded(cfg.DiskExclude, p) {
+			continue
+		}
+		seen[p.Mountpoint] = true
+		usage, err := disk.UsageWithContext(ctx, p.Mountpoint)
+		if err != nil {
+			continue
+		}
+		d := newDiskStats(usage, cfg.Precision)
+		d.Fstype = p.Fstype
+		disks = append(disks, d)
+	}
+	s.Disks = disks
+	return nil
+}
+
+func collectDiskPaths(ctx context.Context, paths []string, places int, s *Stats) error {
+	partitions, _ := disk.PartitionsWithContext(ctx, false)
+	disks := make([]DiskStats, 0, len(paths))
+	var failed partialErrors
+	for _, path := range paths {
+		usage, err := disk.UsageWithContext(ctx, path)
+		if err != nil {
+			if failed == nil {
+				failed = make(partialErrors)
+			}
+			failed[path] = err
+			continue
+		}
+		d := newDiskStats(usage, places)
+		if fstype, ok := mountFstype(partitions, path); ok {
+			d.Fstype = fstype
+		}
+		disks = append(disks, d)
+	}
+	s.Disks = disks
+	if len(disks) == 0 {
+		return fmt.Errorf("no usable disk paths: %w", failed)
+	}
+	if failed != nil {
+		return failed
+	}
+	return nil
+}
+
+// mountFstype returns the filesystem type of the mount holding path, from
+// the partition list. disk.Usage guesses it from the statfs magic number,
+// which ext2, ext3 and ext4 share. Later mounts on the same point win, as
+// they hide the earlier ones.
+func mountFstype(partitions []disk.PartitionStat, path string) (string, bool) {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	path = filepath.Clean(path)
+	best, fstype := -1, ""
+	for _, p := range partitions {
+		mp := filepath.Clean(p.Mountpoint)
+		if path != mp && !strings.HasPrefix(path, strings.TrimSuffix(mp, "/")+"/") {
+			continue
+		}
+		if len(mp) >= best {
+			best, fstype = len(mp), p.Fstype
+		}
+	}
+	return fstype, best >= 0
+}
+
+// diskExcluded reports whether any pattern matches the partition's
+// mountpoint or fstype. Patterns use path.Match syntax, except that a
+// trailing "/*" also matches everything nested below that directory.
+func diskExcluded(patterns []string, p disk.PartitionStat) bool {
+	for _, pattern := range patterns {
+		for _, v := range []string{p.Mountpoint, p.Fstype} {
+			if ok, _ := path.Match(pattern, v); ok {
+				return true
+			}
+			if dir, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(v, dir+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// minRateInterval is the shortest window rates are measured over. Counters
+// like network bytes move in bursts, so callers a few milliseconds apart
+// would otherwise see wildly inflated rates.
+const minRateInterval = time.Second
+
+// rateTracker remembers the previous reading of a set of named monotonic
+// counters so per-second rates can be derived between consecutive calls.
+type rateTracker struct {
+	mu   sync.Mutex
+	at   time.Time
+	prev map[string]uint64
+	last map[string]float64
+}
+
+// rates records cur and returns each counter's per-second rate since the
+// previous call. Counters without a previous reading (including everything
+// on the first call) report 0, as do counters that went backwards after a
+// reset or wrap. Calls within minRateInterval of the recorded reading
+// repeat its rates and keep it as the baseline.
+func (t *rateTracker) rates(cur map[string]uint64, now time.Time) map[string]float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.at.IsZero() && now.Sub(t.at) < minRateInterval {
+		return t.last
+	}
+	elapsed := now.Sub(t.at).Seconds()
+	out := make(map[string]float64, len(cur))
+	for key, v := range cur {
+		if prev, ok := t.prev[key]; ok && !t.at.IsZero() {
+			out[key] = counterRate(prev, v, elapsed)
+		}
+	}
+	t.at = now
+	t.prev = cur
+	t.last = out
+	return out
+}
+
+// counterRate returns the per-second rate between two readings of a
+// monotonic counter. A counter that went backwards (reset or wrap) yields 0.
+func counterRate(prev, cur uint64, elapsed float64) float64 {
+	if elapsed <= 0 || cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / elapsed
+}
+
+var (
+	netRates    rateTracker
+	diskIORates rateTracker
+)
+
+// collectDiskIO reports read/write throughput per block device, derived
+// from the delta against the previous call.
+func collectDiskIO(ctx context.Context, cfg *Config, s *Stats) error {
+	counters, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(counters))
+	cur := make(map[string]uint64, 4*len(counters))
+	for name, c := range counters {
+		names = append(names, name)
+		cur[name+":read"] = c.ReadBytes
+		cur[name+":write"] = c.WriteBytes
+		cur[name+":read_ops"] = c.ReadCount
+		cur[name+":write_ops"] = c.WriteCount
+	}
+	sort.Strings(names)
+	rates := diskIORates.rates(cur, time.Now())
+	mounts := deviceMountpoints(ctx)
+
+	diskIO := DiskIOStats{Devices: make([]DeviceIOStats, 0, len(names))}
+	for _, name := range names {
+		read, write := rates[name+":read"], rates[name+":write"]
+		readOps, writeOps := rates[name+":read_ops"], rates[name+":write_ops"]
+		diskIO.Devices = append(diskIO.Devices, DeviceIOStats{
+			Name:           name,
+			Mountpoint:     mounts[name],
+			ReadBytesRate:  round(read, cfg.Precision),
+			WriteBytesRate: round(write, cfg.Precision),
+			ReadOps:        round(readOps, cfg.Precision),
+			WriteOps:       round(writeOps, cfg.Precision),
+		})
+		if !isPartition(name, counters) {
+			diskIO.ReadBytesRate += read
+			diskIO.WriteBytesRate += write
+			diskIO.ReadOps += readOps
+			diskIO.WriteOps += writeOps
+		}
+	}
+	diskIO.ReadBytesRate = round(diskIO.ReadBytesRate, cfg.Precision)
+	diskIO.WriteBytesRate = round(diskIO.WriteBytesRate, cfg.Precision)
+	diskIO.ReadOps = round(diskIO.ReadOps, cfg.Precision)
+	diskIO.WriteOps = round(diskIO.WriteOps, cfg.Precision)
+	s.DiskIO = diskIO
+	return nil
+}
+
+// deviceMountpoints maps kernel device names, as used by the I/O counters,
+// to where they are mounted. Partitions name devices by path, which may be
+// a symlink such as /dev/mapper/vg-root for dm-0 (LVM, LUKS) or
+// /dev/disk/by-uuid/...; those are resolved as best we can. A device
+// mounted more than once maps to its first mount, before any bind mounts.
+func deviceMountpoints(ctx context.Context) map[string]string {
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return nil
+	}
+	mounts := make(map[string]string, len(partitions))
+	for _, p := range partitions {
+		if !strings.HasPrefix(p.Device, "/dev/") {
+			continue // tmpfs, overlay and the like
+		}
+		dev := p.Device
+		if resolved, err := filepath.EvalSymlinks(dev); err == nil {
+			dev = resolved
+		}
+		name := filepath.Base(dev)
+		if _, ok := mounts[name]; !ok {
+			mounts[name] = p.Mountpoint
+		}
+	}
+	return mounts
+}
+
+// isPartition reports whether name is a partition rather than a whole
+// device. sysfs says so directly; without it, name must be another listed
+// device's name followed by digits, or by "p" and digits when that name
+// ends in a digit (sda1 of sda, nvme0n1p1 of nvme0n1), so loop10 is not
+// taken for a partition of loop1.
+func isPartition(name string, devices map[string]disk.IOCountersStat) bool {
+	if dir := filepath.Join("/sys/class/block", name); fileExists(dir) {
+		return fileExists(filepath.Join(dir, "partition"))
+	}
+	for other := range devices {
+		suffix, ok := strings.CutPrefix(name, other)
+		if !ok || suffix == "" {
+			continue
+		}
+		if last := other[len(other)-1]; last >= '0' && last <= '9' {
+			if suffix, ok = strings.CutPrefix(suffix, "p"); !ok {
+				continue
+			}
+		}
+		if _, err := strconv.ParseUint(suffix, 10, 64); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// loopbackInterfaces returns the names of interfaces flagged as loopback.
+// If the interface list can't be read, it falls back to matching "lo"/"lo0".
+func loopbackInterfaces(ctx context.Context) map[string]bool {
+	names := map[string]bool{}
+	ifaces, err := net.InterfacesWithContext(ctx)
+	if err != nil {
+		names["lo"] = true
+		names["lo0"] = true
+		return names
+	}
+	for _, iface := range ifaces {
+		for _, flag := range iface.Flags {
+			if flag == "loopback" {
+				names[iface.Name] = true
+			}
+		}
+	}
+	return names
+}
+
+// linkSpeed reads an interface's speed in Mbps from sysfs. Interfaces that
+// don't have one (loopback, most virtual devices, links that are down)
+// report -1 or fail the read; both yield 0. Non-Linux platforms always do.
+func linkSpeed(name string) int64 {
+	speed, err := strconv.ParseInt(readSysfs(filepath.Join("/sys/class/net", name), "speed"), 10, 64)
+	if err != nil || speed < 0 {
+		return 0
+	}
+	return speed
+}
+
+// collectNetwork reports per-interface counters; the aggregate is the sum
+// across all of them, or just cfg.NetInterface when that is set. A missing
+// NetInterface leaves the aggregate at zero and is reported as a partial
+// error.
+func collectNetwork(ctx context.Context, cfg *Config, s *Stats) error {
+	netInfo, err := net.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return err
+	}
+	loopback := loopbackInterfaces(ctx)
+	var bytesSent, bytesRecv uint64
+	found := false
+	cur := make(map[string]uint64, 2+4*len(netInfo))
+	for _, nic := range netInfo {
+		if cfg.NetInterface == "" || nic.Name == cfg.NetInterface {
+			bytesSent += nic.BytesSent
+			bytesRecv += nic.BytesRecv
+			found = true
+		}
+		cur[nic.Name+":errin"] = nic.Errin
+		cur[nic.Name+":errout"] = nic.Errout
+		cur[nic.Name+":dropin"] = nic.Dropin
+		cur[nic.Name+":dropout"] = nic.Dropout
+	}
+	cur["sent"] = bytesSent
+	cur["recv"] = bytesRecv
+	rates := netRates.rates(cur, time.Now())
+
+	interfaces := make([]InterfaceStats, 0, len(netInfo))
+	for _, nic := range netInfo {
+		interfaces = append(interfaces, InterfaceStats{
+			Name:        nic.Name,
+			Loopback:    loopback[nic.Name],
+			BytesSent:   nic.BytesSent,
+			BytesRecv:   nic.BytesRecv,
+			PacketsSent: nic.PacketsSent,
+			PacketsRecv: nic.PacketsRecv,
+			Errin:       nic.Errin,
+			Errout:      nic.Errout,
+			Dropin:      nic.Dropin,
+			Dropout:     nic.Dropout,
+			Speed:       linkSpeed(nic.Name),
+			ErrinRate:   round(rates[nic.Name+":errin"], cfg.Precision),
+			ErroutRate:  round(rates[nic.Name+":errout"], cfg.Precision),
+			DropinRate:  round(rates[nic.Name+":dropin"], cfg.Precision),
+			DropoutRate: round(rates[nic.Name+":dropout"], cfg.Precision),
+		})
+	}
+
+	s.Network = NetworkStats{
+		BytesSent: bytesSent,
+		BytesRecv: bytesRecv,
+		SendRate:  round(rates["sent"], cfg.Precision),
+		RecvRate:  round(rates["recv"], cfg.Precision),
+	}
+	s.Interfaces = interfaces
+	if !found && cfg.NetInterface != "" {
+		return partialErrors{cfg.NetInterface: errors.New("no such interface")}
+	}
+	return nil
+}
+
+// recentHistory is the ring buffer that the Load1 trend and anomaly checks
+// compare against; main sets it when there is one.
+var recentHistory *history
+
+// loadTrendThreshold is the smallest Load1 change per minute that counts
+// as a trend rather than noise.
+const loadTrendThreshold = 0.05
+
+// load1Slope returns the change in Load1 per minute from the sample
+// closest to a minute before now, ignoring ones less than 30s or more than
+// 90s old, which would make for a different kind of trend.
+func load1Slope(h *history, load1 float64, now time.Time) (float64, bool) {
+	target := now.Add(-time.Minute)
+	samples, _ := h.samples(now.Add(-90*time.Second), now.Add(-30*time.Second), 0)
+	var past *Stats
+	for _, sample := range samples {
+		if _, failed := sample.Errors["load"]; failed {
+			continue
+		}
+		if past == nil || sample.Timestamp.Sub(target).Abs() < past.Timestamp.Sub(target).Abs() {
+			past = sample
+		}
+	}
+	if past == nil {
+		return 0, false
+	}
+	return (load1 - past.Load.Load1) / now.Sub(past.Timestamp).Minutes(), true
+}
+
+func collectLoad(ctx context.Context, cfg *Config, s *Stats) error {
+	loadInfo, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	s.Load = LoadStats{
+		Load1:  round(loadInfo.Load1, cfg.Precision+1),
+		Load5:  round(loadInfo.Load5, cfg.Precision+1),
+		Load15: round(loadInfo.Load15, cfg.Precision+1),
+	}
+	if logicalCores > 0 {
+		cores := float64(logicalCores)
+		s.Load.Load1PerCore = round(loadInfo.Load1/cores, cfg.Precision+1)
+		s.Load.Load5PerCore = round(loadInfo.Load5/cores, cfg.Precision+1)
+		s.Load.Load15PerCore = round(loadInfo.Load15/cores, cfg.Precision+1)
+	}
+	if recentHistory != nil {
+		if slope, ok := load1Slope(recentHistory, loadInfo.Load1, time.Now()); ok {
+			slope = round(slope, cfg.Precision+1)
+			s.Load.Load1Slope = &slope
+			s.Load.Load1Trend = "stable"
+			if slope >= loadTrendThreshold {
+				s.Load.Load1Trend = "positive"
+			} else if slope <= -loadTrendThreshold {
+				s.Load.Load1Trend = "negative"
+			}
+		}
+	}
+	return nil
+}
+
+// logicalCores is the logical CPU count used to normalize load averages,
+// looked up once at startup. It is 0 if the count couldn't be determined,
+// in which case the per-core figures are left at zero.
+var logicalCores = func() int {
+	n, err := cpu.Counts(true)
+	if err != nil {
+		slog.Warn("Getting CPU count failed", "err", err)
+		return 0
+	}
+	return n
+}()
+
+// cpuInfo is looked up once at startup. Fields that can't be determined
+// are left zero; cpu.Info reports one entry per core (or per package), and
+// they all share a model.
+var cpuInfo = func() CPUInfo {
+	info := CPUInfo{LogicalCores: logicalCores}
+	n, err := cpu.Counts(false)
+	if err != nil {
+		slog.Warn("Getting physical CPU count failed", "err", err)
+	}
+	info.PhysicalCores = n
+	if cpus, err := cpu.Info(); err != nil {
+		slog.Warn("Getting CPU info failed", "err", err)
+	} else if len(cpus) > 0 {
+		info.ModelName = cpus[0].ModelName
+		info.Mhz = cpus[0].Mhz
+	}
+	return info
+}()
+
+func collectHost(ctx context.Context, cfg *Config, s *Stats) error {
+	hostInfo, err := host.InfoWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	bootTime := time.Unix(int64(hostInfo.BootTime), 0)
+	uptime := hostInfo.Uptime
+	var warning error
+	// Migrated VMs can report an uptime that no longer matches the boot
+	// time. Trust the boot time then, since it's what BootTime shows.
+	if drift := time.Since(bootTime) - time.Duration(uptime)*time.Second; drift.Abs() > uptimeDriftTolerance {
+		uptime = uint64(max(time.Since(bootTime), 0) / time.Second)
+		warning = partialErrors{"uptime": fmt.Errorf("reported uptime is %s off boot time; using time since boot", drift.Abs().Round(time.Second))}
+	}
+	s.Uptime = formatUptime(uptime)
+	s.UptimeSeconds = uptime
+	s.System = SystemInfo{
+		OS:              hostInfo.OS,
+		Platform:        hostInfo.Platform,
+		PlatformVersion: hostInfo.PlatformVersion,
+		KernelVersion:   hostInfo.KernelVersion,
+		KernelArch:      hostInfo.KernelArch,
+		BootTime:        bootTime.UTC().Format(time.RFC3339),
+	}
+	return warning
+}
+
+// uptimeDriftTolerance is how far BootTime + Uptime may stray from now
+// before collectHost distrusts the uptime. Both are whole seconds and read
+// at slightly different moments, so they never agree exactly.
+const uptimeDriftTolerance = time.Minute
+
+// collectTemperatures never fails: hosts without sensors (or where they
+// can't be read) just report an empty list. gopsutil may return readings
+// alongside an error for sensors it skipped, so those readings are kept.
+func collectTemperatures(ctx context.Context, cfg *Config, s *Stats) error {
+	temps, err := host.SensorsTemperaturesWithContext(ctx)
+	s.Temperatures = make([]SensorStats, 0, len(temps))
+	for i, t := range temps {
+		s.Temperatures = append(s.Temperatures, SensorStats{
+			SensorKey:   t.SensorKey,
+			Temperature: t.Temperature,
+			High:        t.High,
+			Critical:    t.Critical,
+		})
+		if i == 0 || t.Temperature > s.MaxTemperature {
+			s.MaxTemperature = t.Temperature
+		}
+		if (t.High > 0 && t.Temperature > t.High) || (cfg.TempWarnC > 0 && t.Temperature > cfg.TempWarnC) {
+			s.ThermalWarning = true
+		}
+	}
+	// Unreadable sensors are common and otherwise ignored, but ones hidden
+	// by permissions are worth telling the user about.
+	if permissionDenied(err) {
+		return partialErrors{"sensors": privilegeError(err, "some temperature sensors")}
+	}
+	return nil
+}
+
+// collectFileDescriptors reports system-wide file handle usage from
+// /proc/sys/fs/file-nr ("allocated unused max"). It is Linux-only; other
+// platforms record an error and leave the fields at zero.
+func collectFileDescriptors(ctx context.Context, cfg *Config, s *Stats) error {
+	data, err := os.ReadFile("/proc/sys/fs/file-nr")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 {
+		return fmt.Errorf("unexpected file-nr format %q", strings.TrimSpace(string(data)))
+	}
+	var nums [3]uint64
+	for i, f := range fields {
+		if nums[i], err = strconv.ParseUint(f, 10, 64); err != nil {
+			return fmt.Errorf("parsing file-nr: %w", err)
+		}
+	}
+	// Older kernels count freed-but-allocated handles in the first field;
+	// subtract them to get the handles actually in use.
+	s.OpenFiles = nums[0] - min(nums[1], nums[0])
+	s.MaxFiles = nums[2]
+	if s.MaxFiles > 0 {
+		s.OpenFilesPercent = round(float64(s.OpenFiles)/float64(s.MaxFiles)*100, cfg.Precision)
+	}
+	return nil
+}
+
+// collectUsers lists login sessions. Platforms where host.Users isn't
+// supported just report none.
+func collectUsers(ctx context.Context, cfg *Config, s *Stats) error {
+	users, _ := host.UsersWithContext(ctx)
+	s.Users = make([]UserStats, 0, len(users))
+	for _, u := range users {
+		s.Users = append(s.Users, UserStats{
+			User:     u.User,
+			Terminal: u.Terminal,
+			Host:     u.Host,
+			Started:  time.Unix(int64(u.Started), 0).UTC().Format(time.RFC3339),
+		})
+	}
+	s.UserCount = len(s.Users)
+	return nil
+}
+
+func formatUptime(seconds uint64) string {
+	days := seconds / 86400
+	hours := (seconds % 86400) / 3600
+	minutes := (seconds % 3600) / 60
+
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	} else if hours > 0 {
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		value  float64
+		places int
+		want   float64
+	}{
+		{49.98, 1, 50.0}, // truncating gave 49.9
+		{49.94, 1, 49.9},
+		{49.95, 1, 50.0},
+		{0.04, 1, 0.0},
+		{1.235, 2, 1.24},
+		{1.234, 2, 1.23},
+		{99.999, 2, 100.0},
+		{12.5, 0, 13.0},
+	}
+	for _, tt := range tests {
+		if got := round(tt.value, tt.places); got != tt.want {
+			t.Errorf("round(%v, %d) = %v, want %v", tt.value, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestGetStatsRunsCollectorsConcurrently(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	slow := func(ctx context.Context, cfg *Config, s *Stats) error {
+		time.Sleep(delay)
+		return nil
+	}
+	saved := collectors
+	defer func() { collectors = saved }()
+	collectors = []collector{
+		{"a", slow, nil},
+		{"b", slow, nil},
+		{"c", slow, nil},
+		{"d", slow, nil},
+	}
+
+	cfg := defaultConfig()
+	start := time.Now()
+	if _, err := getStats(context.Background(), cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if sum := delay * time.Duration(len(collectors)); elapsed >= sum/2 {
+		t.Errorf("getStats took %s; collectors sleeping %s in total should overlap", elapsed, sum)
+	}
+}
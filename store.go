@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS samples (
+	ts             INTEGER NOT NULL, -- Unix milliseconds
+	hostname       TEXT    NOT NULL,
+	cpu_percent    REAL    NOT NULL,
+	memory_total   INTEGER NOT NULL,
+	memory_used    INTEGER NOT NULL,
+	memory_percent REAL    NOT NULL,
+	swap_total     INTEGER NOT NULL,
+	swap_used      INTEGER NOT NULL,
+	swap_percent   REAL    NOT NULL,
+	disk_total     INTEGER NOT NULL,
+	disk_used      INTEGER NOT NULL,
+	disk_percent   REAL    NOT NULL,
+	net_bytes_sent INTEGER NOT NULL,
+	net_bytes_recv INTEGER NOT NULL,
+	net_send_rate  REAL    NOT NULL,
+	net_recv_rate  REAL    NOT NULL,
+	load1          REAL    NOT NULL,
+	load5          REAL    NOT NULL,
+	load15         REAL    NOT NULL,
+	uptime_seconds INTEGER NOT NULL,
+	errors         TEXT -- JSON of Stats.Errors; NULL when every collector succeeded
+);
+CREATE INDEX IF NOT EXISTS samples_ts ON samples (ts);
+`
+
+const storeColumns = `ts, hostname, cpu_percent,
+	memory_total, memory_used, memory_percent,
+	swap_total, swap_used, swap_percent,
+	disk_total, disk_used, disk_percent,
+	net_bytes_sent, net_bytes_recv, net_send_rate, net_recv_rate,
+	load1, load5, load15, uptime_seconds, errors`
+
+// store persists the scalar metrics of every sample to SQLite so history
+// survives restarts and can cover much longer spans than the ring buffer.
+// Reads without a start time or limit return the newest defaultLimit rows,
+// like the ring buffer would, rather than the whole table.
+type store struct {
+	db           *sql.DB
+	defaultLimit int
+}
+
+func openStore(path string, defaultLimit int) (*store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateStore(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &store{db: db, defaultLimit: defaultLimit}, nil
+}
+
+// migrateStore creates the schema, adding the errors column to tables
+// created before it existed.
+func migrateStore(db *sql.DB) error {
+	if _, err := db.Exec(storeSchema); err != nil {
+		return err
+	}
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('samples') WHERE name = 'errors'`).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE samples ADD COLUMN errors TEXT`)
+	return err
+}
+
+func (st *store) Close() error {
+	return st.db.Close()
+}
+
+// insert is a sampler sink; failures are logged rather than stopping the
+// sampler.
+func (st *store) insert(s *Stats) {
+	// Failed collectors leave zeros behind; their errors are kept so
+	// readers can tell those from real readings.
+	var errs sql.NullString
+	if len(s.Errors) > 0 {
+		data, err := json.Marshal(s.Errors)
+		if err != nil {
+			slog.Error("Store insert failed", "err", err)
+			return
+		}
+		errs = sql.NullString{String: string(data), Valid: true}
+	}
+	_, err := st.db.Exec(`INSERT INTO samples (`+storeColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.Timestamp.UnixMilli(), s.Hostname, s.CPUPercent,
+		s.Memory.Total, s.Memory.Used, s.Memory.Percent,
+		s.Swap.Total, s.Swap.Used, s.Swap.Percent,
+		s.Disk.Total, s.Disk.Used, s.Disk.Percent,
+		s.Network.BytesSent, s.Network.BytesRecv, s.Network.SendRate, s.Network.RecvRate,
+		s.Load.Load1, s.Load.Load5, s.Load.Load15, s.UptimeSeconds, errs,
+	)
+	if err != nil {
+		slog.Error("Store insert failed", "err", err)
+	}
+}
+
+// samples rebuilds the stored scalar metrics as Stats values; fields that
+// aren't persisted (per-core, per-disk, per-interface...) are left empty.
+func (st *store) samples(from, to time.Time, limit int) ([]*Stats, error) {
+	var fromMs, toMs int64 = 0, 1<<63 - 1
+	if !from.IsZero() {
+		fromMs = from.UnixMilli()
+	}
+	if !to.IsZero() {
+		toMs = to.UnixMilli()
+	}
+	if limit <= 0 {
+		limit = -1 // no limit in SQLite
+		if from.IsZero() && st.defaultLimit > 0 {
+			limit = st.defaultLimit
+		}
+	}
+
+	// Select the newest rows first so LIMIT keeps the most recent ones,
+	// then reverse into chronological order.
+	rows, err := st.db.Query(`SELECT `+storeColumns+` FROM samples
+		WHERE ts BETWEEN ? AND ? ORDER BY ts DESC LIMIT ?`, fromMs, toMs, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*Stats{}
+	for rows.Next() {
+		var s Stats
+		var ts int64
+		var errs sql.NullString
+		err := rows.Scan(&ts, &s.Hostname, &s.CPUPercent,
+			&s.Memory.Total, &s.Memory.Used, &s.Memory.Percent,
+			&s.Swap.Total, &s.Swap.Used, &s.Swap.Percent,
+			&s.Disk.Total, &s.Disk.Used, &s.Disk.Percent,
+			&s.Network.BytesSent, &s.Network.BytesRecv, &s.Network.SendRate, &s.Network.RecvRate,
+			&s.Load.Load1, &s.Load.Load5, &s.Load.Load15, &s.UptimeSeconds, &errs,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if errs.Valid {
+			if err := json.Unmarshal([]byte(errs.String), &s.Errors); err != nil {
+				return nil, err
+			}
+		}
+		s.Timestamp = time.UnixMilli(ts)
+		s.Uptime = formatUptime(s.UptimeSeconds)
+		out = append(out, &s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
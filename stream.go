@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const wsWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{}
+
+// wsHandler pushes every sample from smp to the client as a JSON message
+// until the client disconnects or the server shuts down. Like /api/stats,
+// messages leave out the sections SECTIONS disables.
+func wsHandler(smp *sampler, streams *streamTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // Upgrade has already replied with an HTTP error
+		}
+		defer conn.Close()
+		defer streams.start()()
+
+		updates, unsubscribe := smp.subscribe()
+		defer unsubscribe()
+
+		// Keep reading so control frames are handled; any read error means
+		// the client went away. Closing conn on return ends this goroutine.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case <-streams.closing:
+				msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+				return
+			case stats := <-updates:
+				v, err := smp.cfg.Sections.view(stats)
+				if err != nil {
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := conn.WriteJSON(v); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// sseHandler streams every sample from smp as a Server-Sent Event until the
+// client closes the connection or the server shuts down, trimmed to
+// SECTIONS like the WebSocket messages.
+func sseHandler(smp *sampler, streams *streamTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, "streaming unsupported")
+			return
+		}
+
+		defer streams.start()()
+		updates, unsubscribe := smp.subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-streams.closing:
+				return
+			case stats := <-updates:
+				v, err := smp.cfg.Sections.view(stats)
+				if err != nil {
+					return
+				}
+				data, err := json.Marshal(v)
+				if err != nil {
+					return
+				}
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
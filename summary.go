@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"time"
+)
+
+const defaultSummaryWindow = 5 * time.Minute
+
+type SummaryResponse struct {
+	Window  string     `json:"window"` // as requested
+	From    *time.Time `json:"from"`   // oldest sample used; null when there were none
+	To      *time.Time `json:"to"`
+	Samples int        `json:"samples"`
+	// Metrics of collectors left out of SECTIONS are omitted.
+	CPUPercent    *MetricSummary `json:"cpu_percent,omitempty"`
+	MemoryPercent *MetricSummary `json:"memory_percent,omitempty"`
+	Load1         *MetricSummary `json:"load1,omitempty"`
+}
+
+// MetricSummary aggregates one metric. Samples can be lower than the
+// response total when the metric's collector failed for some of them.
+type MetricSummary struct {
+	Min     float64 `json:"min"`
+	Avg     float64 `json:"avg"`
+	Max     float64 `json:"max"`
+	Samples int     `json:"samples"`
+}
+
+type summaryAcc struct {
+	min, max, sum float64
+	n             int
+}
+
+func (a *summaryAcc) add(v float64) {
+	if a.n == 0 {
+		a.min, a.max = v, v
+	}
+	a.min = math.Min(a.min, v)
+	a.max = math.Max(a.max, v)
+	a.sum += v
+	a.n++
+}
+
+func (a *summaryAcc) summary(places int) *MetricSummary {
+	if a.n == 0 {
+		return &MetricSummary{}
+	}
+	return &MetricSummary{
+		Min:     round(a.min, places),
+		Avg:     round(a.sum/float64(a.n), places),
+		Max:     round(a.max, places),
+		Samples: a.n,
+	}
+}
+
+// summaryHandler aggregates the history over ?window= (default 5m). A
+// window longer than the history simply covers every sample held; From and
+// To report the span actually used.
+func summaryHandler(src historySource, sections sectionSet, places int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		window := defaultSummaryWindow
+		if v := r.URL.Query().Get("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, "window must be a positive duration, e.g. 5m")
+				return
+			}
+			window = d
+		}
+
+		samples, err := src.samples(time.Now().Add(-window), time.Time{}, 0)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		resp := SummaryResponse{Window: window.String(), Samples: len(samples)}
+		if len(samples) > 0 {
+			resp.From = &samples[0].Timestamp
+			resp.To = &samples[len(samples)-1].Timestamp
+		}
+		var cpu, memory, load1 summaryAcc
+		for _, s := range samples {
+			if sections.collected(s, "cpu") {
+				cpu.add(s.CPUPercent)
+			}
+			if sections.collected(s, "memory") {
+				memory.add(s.Memory.Percent)
+			}
+			if sections.collected(s, "load") {
+				load1.add(s.Load.Load1)
+			}
+		}
+		if sections.has("cpu") {
+			resp.CPUPercent = cpu.summary(places)
+		}
+		if sections.has("memory") {
+			resp.MemoryPercent = memory.summary(places)
+		}
+		if sections.has("load") {
+			resp.Load1 = load1.summary(places + 1)
+		}
+		writeJSON(w, r, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultTheme is the look built into index.html. Every other theme is a
+// stylesheet of overrides at static/themes/<name>.css.
+const defaultTheme = "dark"
+
+// staticRoot is the embedded static directory, served at "/".
+var staticRoot, _ = fs.Sub(staticFiles, "static")
+
+// themeExists reports whether name is the default theme or has a
+// stylesheet under static/themes.
+func themeExists(name string) bool {
+	if name == defaultTheme {
+		return true
+	}
+	if name == "" || strings.ContainsAny(name, "/.") {
+		return false
+	}
+	_, err := fs.Stat(staticRoot, "themes/"+name+".css")
+	return err == nil
+}
+
+// staticHandler serves the embedded dashboard. index.html is a template
+// filled in with title (from DASHBOARD_TITLE) and the stylesheet for
+// ?theme=, falling back to theme (from THEME) when that is missing or
+// unknown; everything else is served as a plain file.
+//
+// Responses carry an ETag of their content and may be cached for maxAge
+// seconds, except the page, which is capped at maxPageAge so a new release
+// (and any assets it renames) is picked up reasonably soon.
+func staticHandler(theme, title string, maxAge int) http.Handler {
+	files := http.FileServer(http.FS(staticRoot))
+	etags := staticETags()
+	page := template.Must(template.ParseFS(staticRoot, "index.html"))
+	pageAge := min(maxAge, maxPageAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			// FileServer answers If-None-Match itself from the ETag header.
+			if etag, ok := etags[strings.TrimPrefix(r.URL.Path, "/")]; ok {
+				w.Header().Set("ETag", etag)
+				w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+			}
+			files.ServeHTTP(w, r)
+			return
+		}
+		name := r.URL.Query().Get("theme")
+		if !themeExists(name) {
+			name = theme
+		}
+		data := struct{ Title, Theme string }{Title: title}
+		if name != defaultTheme {
+			data.Theme = name
+		}
+		var buf bytes.Buffer
+		if err := page.Execute(&buf, data); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		etag := contentETag(buf.Bytes())
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(pageAge))
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// maxPageAge caps how long browsers may cache the dashboard page.
+const maxPageAge = 300
+
+// staticETags hashes every embedded file, keyed by its path under static/.
+// The files can't change while running, so this is done once.
+func staticETags() map[string]string {
+	etags := make(map[string]string)
+	fs.WalkDir(staticRoot, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if data, err := fs.ReadFile(staticRoot, path); err == nil {
+			etags[path] = contentETag(data)
+		}
+		return nil
+	})
+	return etags
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// TrendsResponse omits the trends of collectors left out of SECTIONS.
+type TrendsResponse struct {
+	Disk *DiskTrend `json:"disk,omitempty"`
+}
+
+// DiskTrend is the rate at which the monitored disk is filling, fitted by
+// least squares over the samples in history.
+type DiskTrend struct {
+	Mountpoint     string  `json:"mountpoint"`
+	Samples        int     `json:"samples"`
+	WindowSeconds  float64 `json:"window_seconds"`
+	Percent        float64 `json:"percent"` // latest reading
+	PercentPerHour float64 `json:"percent_per_hour"`
+	// TimeToFullSeconds is null when usage is flat or shrinking, or there
+	// aren't enough samples to tell.
+	TimeToFullSeconds *int64 `json:"time_to_full_seconds"`
+}
+
+// trendPlaces keeps enough decimals for slow-growing disks, whose rate is
+// often a small fraction of a percent per hour.
+const trendPlaces = 4
+
+// diskTrend fits disk used-percent against time. Samples where the disk
+// collector failed are skipped.
+func diskTrend(samples []*Stats, mountpoint string) DiskTrend {
+	t := DiskTrend{Mountpoint: mountpoint}
+	var xs, ys []float64
+	var first, last *Stats
+	for _, s := range samples {
+		if _, failed := s.Errors["disk"]; failed {
+			continue
+		}
+		if first == nil {
+			first = s
+		}
+		last = s
+		xs = append(xs, s.Timestamp.Sub(samples[0].Timestamp).Hours())
+		ys = append(ys, s.Disk.Percent)
+	}
+	t.Samples = len(xs)
+	if last == nil {
+		return t
+	}
+	t.Percent = last.Disk.Percent
+	t.WindowSeconds = last.Timestamp.Sub(first.Timestamp).Seconds()
+
+	slope, ok := linearSlope(xs, ys)
+	if !ok {
+		return t
+	}
+	t.PercentPerHour = round(slope, trendPlaces)
+	if t.PercentPerHour == 0 {
+		t.PercentPerHour = 0 // avoid reporting -0 for a slightly shrinking disk
+	}
+	if t.PercentPerHour > 0 {
+		secs := int64((100 - t.Percent) / slope * 3600)
+		t.TimeToFullSeconds = &secs
+	}
+	return t
+}
+
+// linearSlope returns the least-squares slope of ys over xs. It reports
+// false with fewer than two distinct xs.
+func linearSlope(xs, ys []float64) (float64, bool) {
+	n := float64(len(xs))
+	if n < 2 {
+		return 0, false
+	}
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var num, den float64
+	for i := range xs {
+		dx := xs[i] - meanX
+		num += dx * (ys[i] - meanY)
+		den += dx * dx
+	}
+	if den == 0 {
+		return 0, false
+	}
+	return num / den, true
+}
+
+// trendsHandler serves usage trends computed from the stored history.
+func trendsHandler(src historySource, diskPath string, sections sectionSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		samples, err := src.samples(time.Time{}, time.Time{}, 0)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var resp TrendsResponse
+		if sections.has("disk") {
+			t := diskTrend(samples, diskPath)
+			resp.Disk = &t
+		}
+		writeJSON(w, r, http.StatusOK, resp)
+	}
+}